@@ -0,0 +1,295 @@
+// Package acc estimates per-axis offset and gain corrections for
+// accelerometer data by fitting stationary epochs to the gravity sphere.
+package acc
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+)
+
+const (
+	// Number of ICP iterations in the default configuration
+	DefaultIterations = 1000
+
+	// ICP convergence tolerance in the default configuration
+	DefaultTolerance = 1e-6
+
+	// Magnitude of gravity in m/s² in the default configuration. Values in
+	// g-units are converted to m/s² with this factor.
+	DefaultGravity = 9.81
+)
+
+// Settings of the calibration pipeline. Each calibration is given its own
+// Config, so calibrations with different settings may run concurrently.
+type Config struct {
+	// Sample rate of the input records in Hz, which need not be an integer
+	RecordsPerSecond float64
+
+	// Length of an epoch in seconds. An epoch holds
+	// int(EpochSeconds * RecordsPerSecond) records.
+	EpochSeconds float64
+
+	// Number of records between the starts of consecutive epochs. Zero
+	// means the epoch size, giving non-overlapping epochs.
+	Stride int
+
+	// Discard a trailing epoch shorter than the epoch size
+	DropPartial bool
+
+	// Largest gap in seconds between the timestamps of consecutive records
+	// within one recording. Records after a longer gap start a new epoch.
+	// Not split when zero, and the records must carry timestamps otherwise.
+	MaxGap float64
+
+	// Number of records discarded from the start of each epoch, which may
+	// still be settling after the device was moved. Epochs then hold
+	// EpochSize() - Settle records. Overlapping epochs keep the records
+	// discarded from their successors, so with a Stride below Settle each
+	// record is still in some epoch.
+	Settle int
+
+	// Fail on an epoch with an unexpected record count, or on corrections
+	// outside the plausible bounds, instead of warning
+	Strict bool
+
+	// Use the sample SD (N-1 divisor) instead of the population SD (N
+	// divisor) when comparing epochs against the thresholds
+	SampleSD bool
+
+	// Maximum number of goroutines computing epoch statistics
+	Workers int
+
+	// Trim records lying more than Sigma SDs from the epoch mean on any
+	// axis before testing the epoch against the thresholds. Not trimmed
+	// when zero.
+	Sigma float64
+
+	// Test the per-axis median absolute deviation (MAD) of each epoch
+	// against the thresholds instead of the SD. The MAD ignores brief
+	// spikes but, being about 0.6745 SD for normally distributed noise,
+	// needs lower thresholds.
+	Robust bool
+
+	// Minimum length of a run of identical consecutive records, which a
+	// stuck sensor repeats with an artificially zero SD. Runs are counted
+	// and warned about per epoch. Not checked when zero.
+	StuckRun int
+
+	// Discard the epochs holding a stuck run instead of only warning
+	DropStuck bool
+
+	// Per-axis SD, or MAD if Robust is set, in m/s², below which an epoch
+	// is considered stationary. There is no default.
+	Thresholds [3]float64
+
+	// Change of the quantity selected by ConvergeOn between iterations
+	// below which ICP terminates, in m/s² for the RMSE and the offsets
+	Tolerance float64
+
+	// Maximum number of ICP iterations
+	Iterations int
+
+	// Number of weighted least-squares passes per ICP iteration. Each pass
+	// after the first reweights the epochs and solves again against the
+	// same closest points, so at most Iterations * InnerIterations passes
+	// are run. Zero means one.
+	InnerIterations int
+
+	// Parameters fitted by ICP
+	Model ModelKind
+
+	// Axes, indexed X, Y, Z, that ICP leaves uncorrected with offset 0 and
+	// gain 1, such as an axis whose data is known to be bad. Their readings
+	// still enter the norms of the epoch means. At least one axis must be
+	// fitted.
+	FixedAxes [3]bool
+
+	// Quantity whose change terminates ICP, ConvergeRMSE in the default
+	// configuration
+	ConvergeOn ConvergenceKind
+
+	// Norm by which ICP weights epochs
+	WeightNorm NormKind
+
+	// Scale each epoch's ICP weight by the inverse of the mean number of
+	// fitted epochs sharing its records, so that overlapping epochs do not
+	// count the same records several times. This is about Stride /
+	// EpochSize within a run of overlapping epochs and leaves epochs that
+	// share no records unchanged.
+	OverlapWeighting bool
+
+	// Number of static orientations the device was held in. When
+	// positive, the stationary epochs are merged into one per orientation
+	// before fitting, so each orientation carries the same weight.
+	Orientations int
+
+	// Fit each offset as a linear function of the sensor temperature,
+	// d0 + d1*(T - T0), which the records must carry. T0 is the mean
+	// temperature of the epochs. Constant offsets are fitted instead if the
+	// epoch temperatures span less than a degree.
+	TempCompensation bool
+
+	// Magnitude of gravity in m/s², the radius of the sphere ICP fits to
+	Gravity float64
+
+	// Plausible range of the fitted gains and largest plausible magnitude
+	// of the fitted offsets, in m/s². A calibration outside them suggests
+	// bad data and is warned about. Not checked when zero.
+	MinGain, MaxGain float64
+	MaxOffset        float64
+
+	// Largest condition number of the regression of the epoch means below
+	// which the epochs are considered to span enough orientations. Rank
+	// deficient fits are always warned about. Not checked when zero.
+	MaxCondition float64
+
+	// Seed of the random source of randomized steps, so that a run can be
+	// reproduced exactly. No step of the pipeline is randomized yet.
+	Seed int64
+
+	// Called as stages of a calibration advance, with the number of steps
+	// done out of total. The stages are "epochs", counting epochs whose
+	// statistics are computed, and "icp", counting iterations up to
+	// Iterations. Calls never overlap, though those of "epochs" come from
+	// several goroutines. Not called when nil.
+	Progress func(stage string, done, total int)
+}
+
+// Reports progress to c.Progress if it is set
+func (c Config) progress(stage string, done, total int) {
+	if c.Progress != nil {
+		c.Progress(stage, done, total)
+	}
+}
+
+// Returns the default configuration. The thresholds must be set before
+// calibrating.
+func DefaultConfig() Config {
+	return Config{
+		RecordsPerSecond: 30,
+		EpochSeconds:     10.0,
+		Workers:          runtime.NumCPU(),
+		Iterations:       DefaultIterations,
+		Tolerance:        DefaultTolerance,
+		InnerIterations:  1,
+		Model:            ModelSimple,
+		WeightNorm:       NormOfMean,
+		ConvergeOn:       ConvergeRMSE,
+		Gravity:          DefaultGravity,
+		MinGain:          0.8,
+		MaxGain:          1.2,
+		MaxOffset:        0.2 * DefaultGravity,
+		MaxCondition:     100,
+	}
+}
+
+// Returns a random source seeded with c.Seed. Randomized steps draw from
+// their own source rather than the global one of math/rand.
+func (c Config) Rand() *rand.Rand {
+	return rand.New(rand.NewSource(c.Seed))
+}
+
+// Returns a description of each correction outside the plausible bounds
+func (c Config) implausible(corrections []*Correction) []string {
+	problems := make([]string, 0)
+	for _, r := range corrections {
+		if (c.MinGain > 0 && r.Gain < c.MinGain) || (c.MaxGain > 0 && r.Gain > c.MaxGain) {
+			problems = append(problems, fmt.Sprintf("gain %f of axis %c is outside %g to %g", r.Gain, r.Axis, c.MinGain, c.MaxGain))
+		}
+		if c.MaxOffset > 0 && math.Abs(r.Offset) > c.MaxOffset {
+			problems = append(problems, fmt.Sprintf("offset %f m/s² of axis %c exceeds %g m/s²", r.Offset, r.Axis, c.MaxOffset))
+		}
+	}
+
+	return problems
+}
+
+// Returns the number of records in an epoch
+func (c Config) EpochSize() int {
+	return int(c.EpochSeconds * c.RecordsPerSecond)
+}
+
+// Returns an error describing the first invalid setting
+func (c Config) Validate() error {
+	if c.Gravity <= 0 {
+		return errorf(ErrInvalidConfig, "Gravity must be greater than zero")
+	}
+
+	for _, t := range c.Thresholds {
+		if t <= 0 {
+			return errorf(ErrInvalidConfig, "Thresholds must be greater than zero")
+		}
+	}
+
+	if c.Iterations <= 0 {
+		return errorf(ErrInvalidConfig, "The number of iterations must be greater than zero")
+	}
+
+	if c.Tolerance <= 0 {
+		return errorf(ErrInvalidConfig, "The convergence tolerance must be greater than zero")
+	}
+
+	if c.FixedAxes[0] && c.FixedAxes[1] && c.FixedAxes[2] {
+		return errorf(ErrInvalidConfig, "At least one axis must be fitted")
+	}
+
+	if c.InnerIterations < 0 {
+		return errorf(ErrInvalidConfig, "The number of inner iterations must not be negative")
+	}
+
+	if c.StuckRun < 0 || c.StuckRun == 1 {
+		return errorf(ErrInvalidConfig, "A stuck run must span at least 2 records")
+	}
+
+	if c.MinGain < 0 || c.MaxGain < 0 || c.MaxOffset < 0 || c.MaxCondition < 0 {
+		return errorf(ErrInvalidConfig, "The plausible bounds must not be negative")
+	}
+
+	if c.MaxGain > 0 && c.MinGain > c.MaxGain {
+		return errorf(ErrInvalidConfig, "The smallest plausible gain %g exceeds the largest %g", c.MinGain, c.MaxGain)
+	}
+
+	if c.RecordsPerSecond <= 0 || c.EpochSeconds <= 0 {
+		return errorf(ErrInvalidConfig, "The sample rate and the epoch length must be greater than zero")
+	}
+
+	if c.EpochSize() < 1 {
+		return errorf(ErrInvalidConfig, "An epoch of %g s at %g Hz holds no records", c.EpochSeconds, c.RecordsPerSecond)
+	}
+
+	if c.MaxGap < 0 {
+		return errorf(ErrInvalidConfig, "The largest time gap must not be negative")
+	}
+
+	if c.Settle < 0 || c.Settle >= c.EpochSize() {
+		return errorf(ErrInvalidConfig, "Settling must discard between 0 and %d of the %d records of each epoch", c.EpochSize()-1, c.EpochSize())
+	}
+
+	return c.validateKinds()
+}
+
+// Returns an error naming the first of Model, ConvergeOn and WeightNorm
+// that holds none of its defined values
+func (c Config) validateKinds() error {
+	switch c.Model {
+	case ModelSimple, ModelFull:
+	default:
+		return errorf(ErrInvalidConfig, "Unknown model %q", c.Model)
+	}
+
+	switch c.ConvergeOn {
+	case ConvergeRMSE, ConvergeParam:
+	default:
+		return errorf(ErrInvalidConfig, "Unknown convergence metric %q", c.ConvergeOn)
+	}
+
+	switch c.WeightNorm {
+	case NormOfMean, MeanOfNorms:
+	default:
+		return errorf(ErrInvalidConfig, "Unknown weight norm %q", c.WeightNorm)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package acc
+
+import "math"
+
+// Overlapping Allan deviation of each axis at one averaging time
+type AllanPoint struct {
+	// Averaging time in seconds
+	Tau float64
+
+	// Per-axis deviation, in the unit of the records
+	Deviation [3]float64
+}
+
+// Returns the overlapping Allan deviation of each axis of the records,
+// sampled at rate Hz, at averaging times of 1, 2, 4, ... records up to the
+// longest one with at least two averages. Returns nil if there are fewer
+// than two records. The deviation of an axis with missing values is NaN.
+func AllanDeviation(records []*Record, rate float64) []AllanPoint {
+	n := len(records)
+
+	// Prefix sums of the axes, so each average is computed in constant time
+	sums := make([][3]float64, n+1)
+	for i, r := range records {
+		sums[i+1] = [3]float64{sums[i][0] + r.AccX, sums[i][1] + r.AccY, sums[i][2] + r.AccZ}
+	}
+
+	var points []AllanPoint
+	for m := 1; 2*m <= n; m *= 2 {
+		var sq [3]float64
+		for k := 0; k+2*m <= n; k++ {
+			for a := 0; a < 3; a++ {
+				// Difference of the averages of records k..k+m and k+m..k+2m
+				d := (sums[k+2*m][a] - 2*sums[k+m][a] + sums[k][a]) / float64(m)
+				sq[a] += d * d
+			}
+		}
+
+		p := AllanPoint{Tau: float64(m) / rate}
+		terms := float64(n - 2*m + 1)
+		for a := range sq {
+			p.Deviation[a] = math.Sqrt(sq[a] / (2 * terms))
+		}
+		points = append(points, p)
+	}
+
+	return points
+}
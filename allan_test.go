@@ -0,0 +1,91 @@
+package acc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestAllanDeviation(t *testing.T) {
+	alternating := make([][3]float64, 0, 8)
+	for i := 0; i < 8; i++ {
+		v := float64(1 - 2*(i%2))
+		alternating = append(alternating, [3]float64{v, -v, 2 * v})
+	}
+
+	const noise = 0.1
+
+	tests := []struct {
+		name    string
+		records []*Record
+		rate    float64
+
+		// Number of averaging times returned
+		points int
+
+		// Expected deviation of each axis at averaging time tau, checked up
+		// to maxTau
+		want      func(tau float64) [3]float64
+		maxTau    float64
+		tolerance float64
+	}{
+		{"single record", recordsOf([3]float64{1, 2, 3}), 1, 0, nil, 0, 0},
+		{
+			"constant",
+			recordsOf([3]float64{1, 2, 3}, [3]float64{1, 2, 3}, [3]float64{1, 2, 3}, [3]float64{1, 2, 3}, [3]float64{1, 2, 3}),
+			1, 2,
+			func(tau float64) [3]float64 { return [3]float64{} },
+			2, 1e-12,
+		},
+		{
+			// Consecutive records differ by 2, while averages of pairs are
+			// all zero
+			"alternating",
+			recordsOf(alternating...),
+			2, 3,
+			func(tau float64) [3]float64 {
+				if tau == 0.5 {
+					return [3]float64{math.Sqrt2, math.Sqrt2, 2 * math.Sqrt2}
+				}
+				return [3]float64{}
+			},
+			2, 1e-12,
+		},
+		{
+			// White noise falls with slope -1/2 on a log-log plot, from its
+			// SD at one record
+			"white noise",
+			noisyRecords(rand.New(rand.NewSource(1)), 1<<14, [3]float64{0, 9.81, 0}, noise),
+			100, 14,
+			func(tau float64) [3]float64 {
+				d := noise / math.Sqrt(tau*100)
+				return [3]float64{d, d, d}
+			},
+			0.16, 0.002,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			points := AllanDeviation(test.records, test.rate)
+			if len(points) != test.points {
+				t.Fatalf("got %d averaging times, want %d", len(points), test.points)
+			}
+
+			for i, p := range points {
+				if want := float64(int(1)<<i) / test.rate; p.Tau != want {
+					t.Errorf("point %d: got averaging time %g, want %g", i, p.Tau, want)
+				}
+				if p.Tau > test.maxTau {
+					continue
+				}
+				want := test.want(p.Tau)
+				for k, got := range p.Deviation {
+					if !closeTo(got, want[k], test.tolerance) {
+						t.Errorf("deviation %c at %g s = %g, want %g", axes[k], p.Tau, got, want[k])
+					}
+				}
+			}
+		})
+	}
+}
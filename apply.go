@@ -0,0 +1,138 @@
+package acc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Returns a copy of records with each axis corrected as Gain*raw + Offset,
+// or Matrix . raw + Offset for corrections of the full model. The offset
+// of a temperature-compensated correction is taken at the record's Temp. Corrections
+// are matched to axes by their Axis field; an axis without a correction is
+// copied unchanged.
+func ApplyCorrections(records []*Record, corrections []*Correction) []*Record {
+	x, y, z := identity('X'), identity('Y'), identity('Z')
+	for _, c := range corrections {
+		switch c.Axis {
+		case 'X':
+			x = c
+		case 'Y':
+			y = c
+		case 'Z':
+			z = c
+		}
+	}
+
+	corrected := make([]*Record, 0, len(records))
+	for _, r := range records {
+		corrected = append(corrected, &Record{
+			AccX: x.apply(r, r.AccX),
+			AccY: y.apply(r, r.AccY),
+			AccZ: z.apply(r, r.AccZ),
+			Time: r.Time,
+			Temp: r.Temp,
+
+			Orientation: r.Orientation,
+		})
+	}
+
+	return corrected
+}
+
+// Returns the corrected value of the axis whose raw value in r is raw
+func (c *Correction) apply(r *Record, raw float64) float64 {
+	offset := c.Offset + c.TempSlope*(r.Temp-c.RefTemp)
+	if len(c.Matrix) == 3 {
+		return c.Matrix[0]*r.AccX + c.Matrix[1]*r.AccY + c.Matrix[2]*r.AccZ + offset
+	}
+
+	return c.Gain*raw + offset
+}
+
+func identity(axis rune) *Correction {
+	return &Correction{
+		Axis:   axis,
+		Offset: 0,
+		Gain:   1,
+	}
+}
+
+// Reads corrections previously written as JSON, either as an array of
+// corrections or as an object holding them under "corrections". Exactly one
+// correction is required for each of X, Y and Z.
+func ReadCorrections(in io.Reader) ([]*Correction, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var corrections []*Correction
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &corrections)
+	} else {
+		var wrapped struct {
+			Corrections []*Correction `json:"corrections"`
+		}
+		err = json.Unmarshal(data, &wrapped)
+		corrections = wrapped.Corrections
+	}
+	if err != nil {
+		return nil, errorf(ErrInvalidCorrections, "Invalid corrections: %w", err)
+	}
+
+	seen := make(map[rune]bool)
+	for _, c := range corrections {
+		if c.Axis != 'X' && c.Axis != 'Y' && c.Axis != 'Z' {
+			return nil, errorf(ErrInvalidCorrections, "Invalid corrections: unknown axis %c", c.Axis)
+		}
+		if seen[c.Axis] {
+			return nil, errorf(ErrInvalidCorrections, "Invalid corrections: duplicate axis %c", c.Axis)
+		}
+		if c.Matrix != nil && len(c.Matrix) != 3 {
+			return nil, errorf(ErrInvalidCorrections, "Invalid corrections: matrix row of axis %c must have 3 elements", c.Axis)
+		}
+		seen[c.Axis] = true
+	}
+	if len(seen) != 3 {
+		return nil, errorf(ErrInvalidCorrections, "Invalid corrections: expected axes X, Y and Z, got %d", len(seen))
+	}
+
+	return corrections, nil
+}
+
+// Reads the corrections in the JSON file at filePath
+func ReadCorrectionsFile(filePath string) ([]*Correction, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read corrections file at path %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	corrections, err := ReadCorrections(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, filePath)
+	}
+
+	return corrections, nil
+}
+
+// Returns ||mean|| - gravity for the mean vector of every non-empty epoch
+// after applying the corrections. Passing no corrections gives the raw
+// residuals.
+func Residuals(epochs []*Epoch, corrections []*Correction, gravity float64) []float64 {
+	residuals := make([]float64, 0, len(epochs))
+	for _, e := range epochs {
+		if len(e.Records) == 0 {
+			continue
+		}
+
+		x, y, z := e.Mean()
+		mean := ApplyCorrections([]*Record{{AccX: x, AccY: y, AccZ: z, Temp: e.meanTemp()}}, corrections)[0]
+		residuals = append(residuals, euclidean([3]float64{mean.AccX, mean.AccY, mean.AccZ})-gravity)
+	}
+
+	return residuals
+}
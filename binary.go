@@ -0,0 +1,161 @@
+package acc
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Type of the X, Y and Z samples of a binary input
+type SampleType string
+
+const (
+	// IEEE 754 single-precision floats
+	SampleFloat32 SampleType = "float32"
+
+	// Signed 16-bit integers, such as raw ADC counts
+	SampleInt16 SampleType = "int16"
+)
+
+// Returns the number of bytes of one sample
+func (t SampleType) size() int {
+	if t == SampleInt16 {
+		return 2
+	}
+
+	return 4
+}
+
+// Controls how ReadBinaryRecords interprets its input, which holds packed
+// records of an X, a Y and a Z sample each with no header
+type BinaryOptions struct {
+	// Type of the samples. Defaults to SampleFloat32 when empty.
+	Sample SampleType
+
+	// Byte order of the samples is big-endian instead of little-endian
+	BigEndian bool
+
+	// Factor converting a sample to Units, such as the sensitivity of an
+	// ADC in g per count. Defaults to 1 when zero.
+	Scale float64
+
+	// Log and skip malformed records instead of failing
+	SkipBadRows bool
+
+	// Largest plausible magnitude of an axis value, in Units. Records with
+	// larger values are malformed. Not checked when zero.
+	MaxAbs float64
+
+	// Stop reading once this many records have been parsed. Unlimited
+	// when zero or negative.
+	MaxRecords int
+
+	// Unit of the scaled axis values, which are converted to m/s².
+	// Defaults to UnitMS2 when empty.
+	Units Unit
+}
+
+// Returns an error describing the first invalid option
+func (opts BinaryOptions) validate() error {
+	if opts.Sample != "" && opts.Sample != SampleFloat32 && opts.Sample != SampleInt16 {
+		return errorf(ErrInvalidConfig, "Unknown sample type %q", opts.Sample)
+	}
+
+	if math.IsNaN(opts.Scale) || math.IsInf(opts.Scale, 0) {
+		return errorf(ErrInvalidConfig, "The scale must be a finite number")
+	}
+
+	return nil
+}
+
+// Reads the records of the binary file at filePath, or of stdin when
+// filePath is empty or "-"
+func ReadBinaryFile(filePath string, opts BinaryOptions) ([]*Record, error) {
+	return readFile(filePath, func(in io.Reader) ([]*Record, error) {
+		return ReadBinaryRecords(in, opts)
+	})
+}
+
+// Reads packed records of three samples each from the stream. The stream
+// may be gzip-compressed. Returns an error if it holds no records or ends
+// within a record.
+func ReadBinaryRecords(in io.Reader, opts BinaryOptions) ([]*Record, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	in, err := maybeGunzip(in)
+	if err != nil {
+		return nil, errorf(ErrParse, "Invalid gzip stream: %w", err)
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if opts.BigEndian {
+		order = binary.BigEndian
+	}
+
+	scale := opts.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	f := opts.Units.toMS2()
+
+	size := opts.Sample.size()
+	buf := make([]byte, 3*size)
+
+	records := make([]*Record, 0)
+	n := 0
+	skipped := 0
+	for opts.MaxRecords <= 0 || len(records) < opts.MaxRecords {
+		read, err := io.ReadFull(in, buf)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return nil, errorf(ErrParse, "The input ends with a partial record of %d bytes", read)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n++
+
+		var values [3]float64
+		for k := range values {
+			b := buf[k*size : (k+1)*size]
+			if opts.Sample == SampleInt16 {
+				values[k] = float64(int16(order.Uint16(b)))
+			} else {
+				values[k] = float64(math.Float32frombits(order.Uint32(b)))
+			}
+			values[k] *= scale
+		}
+
+		if err := checkAxes(values, opts.MaxAbs); err != nil {
+			if !opts.SkipBadRows {
+				return nil, errorf(ErrParse, "Record %d: %w", n, err)
+			}
+
+			log.Warnf("Skipping record %d: %s", n, err.Error())
+			skipped++
+			continue
+		}
+
+		records = append(records, &Record{
+			AccX: values[0] * f,
+			AccY: values[1] * f,
+			AccZ: values[2] * f,
+		})
+	}
+
+	if skipped > 0 {
+		log.Warnf("Skipped %d malformed records", skipped)
+	}
+
+	if err := checkNotEmpty(records, skipped); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
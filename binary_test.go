@@ -0,0 +1,120 @@
+package acc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// Returns the samples packed in the given byte order
+func packSamples(t *testing.T, order binary.ByteOrder, samples interface{}) []byte {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, order, samples); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestReadBinaryRecords(t *testing.T) {
+	g := UnitG.toMS2()
+
+	tests := []struct {
+		name  string
+		order binary.ByteOrder
+		// Samples as written, of the type opts.Sample selects
+		samples interface{}
+		opts    BinaryOptions
+		want    []Record
+	}{
+		{
+			"float32",
+			binary.LittleEndian,
+			[]float32{0.5, 9.75, -0.25, 1, 2, 3},
+			BinaryOptions{},
+			[]Record{{AccX: 0.5, AccY: 9.75, AccZ: -0.25}, {AccX: 1, AccY: 2, AccZ: 3}},
+		},
+		{
+			"float32 big-endian",
+			binary.BigEndian,
+			[]float32{0.5, 9.75, -0.25},
+			BinaryOptions{Sample: SampleFloat32, BigEndian: true},
+			[]Record{{AccX: 0.5, AccY: 9.75, AccZ: -0.25}},
+		},
+		{
+			"int16 little-endian",
+			binary.LittleEndian,
+			[]int16{0, 4096, -2048},
+			BinaryOptions{Sample: SampleInt16, Scale: 1.0 / 4096, Units: UnitG},
+			[]Record{{AccX: 0, AccY: g, AccZ: -0.5 * g}},
+		},
+		{
+			"int16 big-endian",
+			binary.BigEndian,
+			[]int16{-4096, 1024, 32767},
+			BinaryOptions{Sample: SampleInt16, BigEndian: true, Scale: 0.5},
+			[]Record{{AccX: -2048, AccY: 512, AccZ: 16383.5}},
+		},
+		{
+			"skipped beyond MaxAbs",
+			binary.LittleEndian,
+			[]int16{100, 200, 300, 4096, 0, 0, -5000, 0, 0},
+			BinaryOptions{Sample: SampleInt16, Scale: 1.0 / 1024, MaxAbs: 4, SkipBadRows: true},
+			[]Record{{AccX: 100.0 / 1024, AccY: 200.0 / 1024, AccZ: 300.0 / 1024}, {AccX: 4}},
+		},
+		{
+			"max records",
+			binary.LittleEndian,
+			[]float32{1, 2, 3, 4, 5, 6, 7, 8, 9},
+			BinaryOptions{MaxRecords: 2},
+			[]Record{{AccX: 1, AccY: 2, AccZ: 3}, {AccX: 4, AccY: 5, AccZ: 6}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			in := bytes.NewReader(packSamples(t, test.order, test.samples))
+			records, err := ReadBinaryRecords(in, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(test.want) {
+				t.Fatalf("got %d records, want %d", len(records), len(test.want))
+			}
+			for i, r := range records {
+				if *r != test.want[i] {
+					t.Errorf("record %d: got %+v, want %+v", i, *r, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadBinaryRecordsMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		opts    BinaryOptions
+		wantErr error
+	}{
+		{"empty", nil, BinaryOptions{}, ErrNoData},
+		{"partial record", packSamples(t, binary.LittleEndian, []float32{1, 2, 3, 4}), BinaryOptions{}, ErrParse},
+		{"partial int16 record", packSamples(t, binary.LittleEndian, []int16{1, 2, 3, 4, 5}), BinaryOptions{Sample: SampleInt16}, ErrParse},
+		{"beyond MaxAbs", packSamples(t, binary.LittleEndian, []int16{1, 2, 3, 400, 5, 6}), BinaryOptions{Sample: SampleInt16, MaxAbs: 100}, ErrParse},
+		{"all records skipped", packSamples(t, binary.LittleEndian, []int16{400, 5, 6}), BinaryOptions{Sample: SampleInt16, MaxAbs: 100, SkipBadRows: true}, ErrNoData},
+		{"unknown sample type", packSamples(t, binary.LittleEndian, []int16{1, 2, 3}), BinaryOptions{Sample: "int8"}, ErrInvalidConfig},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ReadBinaryRecords(bytes.NewReader(test.input), test.opts)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+			if records != nil {
+				t.Errorf("got %d records, want none", len(records))
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package acc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Outcome of Calibrate
+type Calibration struct {
+	Corrections []*Correction
+	Diagnostics *Diagnostics
+
+	// The stationary epochs the corrections were fitted to, merged into one
+	// per orientation if Config.Orientations is set
+	Epochs []*Epoch
+
+	// Every epoch the records were split into, stationary or not
+	AllEpochs []*Epoch
+
+	// Wall-clock time spent selecting and merging the stationary epochs,
+	// and fitting them with ICP
+	PreProcessDuration time.Duration
+	ICPDuration        time.Duration
+}
+
+// Splits the records, which are in m/s², into epochs, retains the
+// stationary ones and fits corrections to them with ICP.
+// Returns the context's error if it is cancelled.
+func Calibrate(ctx context.Context, records []*Record, cfg Config) (*Calibration, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	epochs, err := GetEpochs(records, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateEpochs(epochs, cfg); err != nil {
+		return nil, err
+	}
+
+	return CalibrateEpochs(ctx, epochs, cfg)
+}
+
+// Retains the stationary epochs and fits corrections to them with ICP. Use
+// this instead of Calibrate to combine epochs split from several inputs, so
+// that no epoch spans two of them. Corrections outside the plausible bounds
+// of cfg are logged, or returned as an error if cfg.Strict is set.
+// Returns the context's error if it is cancelled.
+func CalibrateEpochs(ctx context.Context, allEpochs []*Epoch, cfg Config) (*Calibration, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	epochs, err := PreProcessEpochs(ctx, allEpochs, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Orientations > 0 {
+		epochs = ClusterEpochs(epochs, cfg.Orientations)
+	}
+	preProcessed := time.Now()
+
+	corrections, diag, err := ICP(ctx, epochs, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if problems := cfg.implausible(corrections); len(problems) > 0 {
+		if cfg.Strict {
+			return nil, errorf(ErrImplausible, "Implausible corrections: %s", strings.Join(problems, "; "))
+		}
+		for _, p := range problems {
+			log.Warnf("Implausible correction: %s", p)
+		}
+		diag.Implausible = problems
+	}
+
+	return &Calibration{
+		Corrections: corrections,
+		Diagnostics: diag,
+		Epochs:      epochs,
+		AllEpochs:   allEpochs,
+
+		PreProcessDuration: preProcessed.Sub(start),
+		ICPDuration:        time.Since(preProcessed),
+	}, nil
+}
@@ -0,0 +1,96 @@
+package acc
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "regenerate the fixtures in testdata")
+
+// Parameters of a synthetic recording: a sensor with offsets d and gains a,
+// held still in the given number of random orientations for epochSize
+// records each
+type synthetic struct {
+	d, a         [3]float64
+	noise        float64
+	orientations int
+	epochSize    int
+	seed         int64
+}
+
+// The recording in testdata/synthetic.csv
+var syntheticFixture = synthetic{
+	d:            [3]float64{0.3, -0.2, 0.15},
+	a:            [3]float64{1.05, 0.97, 1.02},
+	noise:        0.01,
+	orientations: 40,
+	epochSize:    50,
+	seed:         1,
+}
+
+// Writes the recording as X,Y,Z rows, one epoch after the other
+func (s synthetic) write(path string) error {
+	rng := rand.New(rand.NewSource(s.seed))
+	epochs := orientationEpochs(rng, s.orientations, s.epochSize, s.d, s.a, s.noise)
+
+	var records []*Record
+	for _, e := range epochs {
+		records = append(records, e.Records...)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSVRecords(&buf, records, 5); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// Calibrates the committed recording of a sensor with known offsets and
+// gains. Run with -update to regenerate it from syntheticFixture, then
+// update the golden files of cmd/acc, which calibrate it too.
+func TestCalibrateSynthetic(t *testing.T) {
+	s := syntheticFixture
+	path := filepath.Join("testdata", "synthetic.csv")
+	if *update {
+		if err := s.write(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := ReadCSVFile(path, CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.RecordsPerSecond = 10
+	cfg.EpochSeconds = float64(s.epochSize) / cfg.RecordsPerSecond
+	cfg.Thresholds = [3]float64{0.05, 0.05, 0.05}
+
+	calibration, err := Calibrate(context.Background(), records, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(calibration.Epochs); n != s.orientations {
+		t.Errorf("retained %d epochs, want %d", n, s.orientations)
+	}
+	if !calibration.Diagnostics.Converged {
+		t.Errorf("did not converge in %d iterations", calibration.Diagnostics.Iterations)
+	}
+	for k, c := range calibration.Corrections {
+		if math.Abs(c.Offset-s.d[k]) > 2e-3 {
+			t.Errorf("%c: got offset %g, want %g", c.Axis, c.Offset, s.d[k])
+		}
+		if math.Abs(c.Gain-s.a[k]) > 2e-4 {
+			t.Errorf("%c: got gain %g, want %g", c.Axis, c.Gain, s.a[k])
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package acc
+
+import (
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Largest angle in degrees between the mean direction of an epoch and that
+// of the orientation cluster it joins
+const clusterAngle = 20.0
+
+// Groups the non-empty epochs by the direction of their mean vectors and
+// returns one epoch per group holding the records of all its members. An
+// epoch joins the first group whose mean direction is within clusterAngle
+// degrees of its own, otherwise it starts a new group. Warns if the number
+// of groups differs from expected.
+func ClusterEpochs(epochs []*Epoch, expected int) []*Epoch {
+	type cluster struct {
+		// Sum of the unit mean vectors of the members
+		sum     [3]float64
+		records []*Record
+	}
+
+	minCos := math.Cos(clusterAngle * math.Pi / 180)
+	clusters := make([]*cluster, 0, expected)
+	for _, e := range epochs {
+		if len(e.Records) == 0 {
+			continue
+		}
+
+		x, y, z := e.Mean()
+		m := [3]float64{x, y, z}
+		norm := euclidean(m)
+		if norm == 0 {
+			continue
+		}
+		for k := range m {
+			m[k] /= norm
+		}
+
+		var joined *cluster
+		for _, c := range clusters {
+			n := euclidean(c.sum)
+			if (c.sum[0]*m[0]+c.sum[1]*m[1]+c.sum[2]*m[2])/n >= minCos {
+				joined = c
+				break
+			}
+		}
+		if joined == nil {
+			joined = &cluster{}
+			clusters = append(clusters, joined)
+		}
+
+		for k := range m {
+			joined.sum[k] += m[k]
+		}
+		joined.records = append(joined.records, e.Records...)
+	}
+
+	if len(clusters) != expected {
+		log.Warnf("Found %d static orientations, expected %d", len(clusters), expected)
+	} else {
+		log.Infof("Found %d static orientations", len(clusters))
+	}
+
+	merged := make([]*Epoch, 0, len(clusters))
+	for _, c := range clusters {
+		merged = append(merged, &Epoch{Records: c.records, Index: -1})
+	}
+
+	return merged
+}
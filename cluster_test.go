@@ -0,0 +1,71 @@
+package acc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestClusterEpochs(t *testing.T) {
+	faces := [][3]float64{{1, 0, 0}, {-1, 0, 0}, {0, 1, 0}, {0, -1, 0}, {0, 0, 1}, {0, 0, -1}}
+	angle := 30 * math.Pi / 180
+
+	tests := []struct {
+		name string
+		// Directions of gravity, each labelled with its own orientation
+		directions [][3]float64
+	}{
+		{"one orientation", faces[4:5]},
+		{"up and down", faces[4:]},
+		{"six faces", faces},
+		{"30 degrees apart", [][3]float64{{0, 0, 1}, {math.Sin(angle), 0, math.Cos(angle)}}},
+	}
+
+	const reps, size = 3, 20
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The epochs of each orientation are interleaved with those of
+			// the others and tilted by up to about 8 degrees, and an empty
+			// epoch is skipped
+			rng := rand.New(rand.NewSource(1))
+			epochs := []*Epoch{{}}
+			for rep := 0; rep < reps; rep++ {
+				for i, dir := range test.directions {
+					tilted := dir
+					for k := range tilted {
+						tilted[k] += 0.05 * float64(rep)
+					}
+					norm := euclidean(tilted)
+					for k := range tilted {
+						tilted[k] *= DefaultGravity / norm
+					}
+
+					records := noisyRecords(rng, size, tilted, 0.01)
+					for _, r := range records {
+						r.Orientation = Orientation(i + 1)
+					}
+					epochs = append(epochs, &Epoch{Records: records, Index: len(epochs)})
+				}
+			}
+
+			clusters := ClusterEpochs(epochs, len(test.directions))
+			if len(clusters) != len(test.directions) {
+				t.Fatalf("got %d clusters, want %d", len(clusters), len(test.directions))
+			}
+			for i, c := range clusters {
+				if len(c.Records) != reps*size {
+					t.Errorf("cluster %d holds %d records, want %d", i, len(c.Records), reps*size)
+				}
+				if c.Index != -1 {
+					t.Errorf("cluster %d has index %d, want -1", i, c.Index)
+				}
+				for _, r := range c.Records {
+					if r.Orientation != c.Records[0].Orientation {
+						t.Errorf("cluster %d mixes orientations %v and %v", i, c.Records[0].Orientation, r.Orientation)
+						break
+					}
+				}
+			}
+		})
+	}
+}
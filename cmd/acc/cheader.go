@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Writes the corrections of res as C preprocessor definitions with the given
+// number of decimal places, for compiling into firmware
+func writeCHeader(w io.Writer, res *fileResult, precision int) error {
+	bw := bufio.NewWriter(w)
+	define := func(name string, v float64) {
+		literal := strconv.FormatFloat(v, 'f', precision, 64)
+		// A float literal needs a decimal point before its suffix
+		if !strings.Contains(literal, ".") {
+			literal += ".0"
+		}
+		fmt.Fprintf(bw, "#define %s %sf\n", name, literal)
+	}
+
+	fmt.Fprintf(bw, "/* Accelerometer calibration of %s, generated by acc %s */\n", res.File, version)
+	fmt.Fprintln(bw, "#ifndef ACC_CALIBRATION_H")
+	fmt.Fprintln(bw, "#define ACC_CALIBRATION_H")
+	fmt.Fprintln(bw)
+
+	for _, c := range res.Corrections {
+		define(fmt.Sprintf("ACC_OFFSET_%c", c.Axis), c.Offset)
+		define(fmt.Sprintf("ACC_GAIN_%c", c.Axis), c.Gain)
+		if res.tempCompensated {
+			define(fmt.Sprintf("ACC_TEMP_SLOPE_%c", c.Axis), c.TempSlope)
+			define(fmt.Sprintf("ACC_REF_TEMP_%c", c.Axis), c.RefTemp)
+		}
+	}
+
+	if len(res.Corrections) > 0 && res.Corrections[0].Matrix != nil {
+		fmt.Fprintln(bw)
+		fmt.Fprintln(bw, "/* Scale and misalignment matrix, row per corrected axis */")
+		for _, c := range res.Corrections {
+			for j, col := range "XYZ" {
+				define(fmt.Sprintf("ACC_MATRIX_%c%c", c.Axis, col), c.Matrix[j])
+			}
+		}
+	}
+
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "#endif")
+
+	return bw.Flush()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/tomcat-bit/acc"
+)
+
+// Width of the longest bar of an ASCII histogram
+const histWidth = 50
+
+// Writes ASCII histograms of the residuals of the retained epochs before
+// and after correction from the sphere of radius gravity, sharing the same
+// nBins bins
+func writeHistograms(w io.Writer, res *fileResult, nBins int, u acc.Unit, gravity float64, p int) {
+	f := acc.ConvertToMS2(1, u)
+	before := acc.Residuals(res.epochs, nil, gravity)
+	after := acc.Residuals(res.epochs, res.corrections, gravity)
+	for i := range before {
+		before[i] /= f
+	}
+	for i := range after {
+		after[i] /= f
+	}
+
+	if len(before) == 0 {
+		return
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, r := range append(append([]float64{}, before...), after...) {
+		lo = math.Min(lo, r)
+		hi = math.Max(hi, r)
+	}
+	if hi == lo {
+		hi = lo + 1
+	}
+
+	fmt.Fprintln(w, "Residuals ||acc|| - g before correction:")
+	writeHistogram(w, histogram(before, nBins, lo, hi), lo, hi, p)
+	fmt.Fprintln(w, "Residuals ||acc|| - g after correction:")
+	writeHistogram(w, histogram(after, nBins, lo, hi), lo, hi, p)
+}
+
+// Returns the number of values in each of nBins equal bins over [lo, hi]
+func histogram(values []float64, nBins int, lo, hi float64) []int {
+	counts := make([]int, nBins)
+	for _, v := range values {
+		bin := int((v - lo) / (hi - lo) * float64(nBins))
+		if bin >= nBins {
+			bin = nBins - 1
+		}
+		counts[bin]++
+	}
+
+	return counts
+}
+
+func writeHistogram(w io.Writer, counts []int, lo, hi float64, p int) {
+	max := 0
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+
+	width := (hi - lo) / float64(len(counts))
+	for i, c := range counts {
+		bar := 0
+		if max > 0 {
+			bar = c * histWidth / max
+		}
+		fmt.Fprintf(w, "[% .*f, % .*f) %5d %s\n", p, lo+float64(i)*width, p, lo+float64(i+1)*width, c, strings.Repeat("#", bar))
+	}
+}
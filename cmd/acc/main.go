@@ -0,0 +1,902 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tomcat-bit/acc"
+)
+
+func main() {
+	start := time.Now()
+
+	var threshold string
+	var file string
+	var iterations int
+	var tolerance float64
+	var innerIterations int
+	var header bool
+	var delim string
+	var decimalComma bool
+	var skipBadRows bool
+	var format string
+	var out string
+	var hz float64
+	var epochSeconds float64
+	var sampleSD bool
+	var workers int
+	var model string
+	var gravity float64
+	var stride int
+	var dropPartial bool
+	var timeColumn int
+	var tempColumn int
+	var orientationColumn int
+	var dir string
+	var showVersion bool
+	var logLevel string
+	var logFormat string
+	var strict bool
+	var units string
+	var stats bool
+	var allan bool
+	var inputFormat string
+	var jsonKeys string
+	var apply string
+	var weightNorm string
+	var convergeOn string
+	var histBins int
+	var cols string
+	var comment string
+	var maxAbs float64
+	var reportPath string
+	var decimate int
+	var orientations int
+	var maxRecords int
+	var showProgress bool
+	var prec int
+	var folds int
+	var smooth int
+	var sigma float64
+	var normalize bool
+	var robust bool
+	var showTiming bool
+	var allowMissing bool
+	var merge string
+	var gainBounds string
+	var maxOffset float64
+	var quiet bool
+	var seed int64
+	var stuckRun int
+	var dropStuck bool
+	var overlapWeight bool
+	var dumpEpochs string
+	var binSpec string
+	var maxCondition float64
+	var fitAxes string
+	var settle int
+	var inputRange string
+	var clamp bool
+	var maxGap float64
+
+	args := flag.NewFlagSet("args", flag.ExitOnError)
+	args.StringVar(&file, "f", "-", "Input file to parse. Reads from stdin when omitted or '-'. Comma-separated files are calibrated as one dataset, with no epoch spanning two files.")
+	args.StringVar(&threshold, "t", "", "Per-axis SD below which an epoch is considered stationary. Either one value or comma-separated X,Y,Z values for the per-axis SD test. Each value is in -units, or a percentage of -g such as 1%.")
+	args.Float64Var(&tolerance, "tol", acc.DefaultTolerance, "ICP stops once the quantity selected by -converge-on changes by less than this between iterations, in m/s² for the RMSE and the offsets.")
+	args.IntVar(&iterations, "max-iter", acc.DefaultIterations, "Maximum number of ICP iterations.")
+	args.IntVar(&iterations, "n", acc.DefaultIterations, "Alias of -max-iter.")
+	args.IntVar(&innerIterations, "inner-iter", 1, "Number of weighted least-squares passes per ICP iteration. Passes after the first reweight the epochs against the same closest points. At most -max-iter times this many passes are run.")
+	args.BoolVar(&header, "header", false, "Skip the first row of the CSV file.")
+	args.StringVar(&delim, "delim", ",", "CSV field delimiter, e.g. ';', '\\t' or '|'.")
+	args.BoolVar(&decimalComma, "decimal-comma", false, "Parse ',' as the decimal point.")
+	args.BoolVar(&skipBadRows, "skip-bad-rows", false, "Log and skip malformed rows instead of exiting.")
+	args.StringVar(&format, "o", "log", "Output format of the corrections: log, table (aligned columns on stdout), json or cheader (C #define header).")
+	args.IntVar(&prec, "precision", 6, "Number of decimal places of the corrections, statistics and records written, in every output format. By default the log, cheader and table output use 6 and JSON and CSV output use as many as needed to read back the exact value.")
+	args.StringVar(&out, "out", "", "Write the corrected records as CSV to this path.")
+	args.Float64Var(&hz, "hz", acc.DefaultConfig().RecordsPerSecond, "Sample rate of the input in Hz.")
+	args.Float64Var(&epochSeconds, "epoch", acc.DefaultConfig().EpochSeconds, "Epoch length in seconds. Each epoch holds epoch * hz records.")
+	args.BoolVar(&sampleSD, "sample-sd", false, "Compare the sample SD (N-1) of each epoch against -t instead of the population SD (N).")
+	args.IntVar(&workers, "workers", acc.DefaultConfig().Workers, "Maximum number of goroutines computing epoch statistics.")
+	args.StringVar(&model, "model", string(acc.ModelSimple), "Calibration model: simple (per-axis offset and gain) or full (lower triangular 3x3 matrix and offset).")
+	args.Float64Var(&gravity, "g", acc.DefaultGravity, "Local magnitude of gravity in m/s².")
+	args.IntVar(&stride, "stride", 0, "Number of records between the starts of consecutive epochs, counting records after -decimate as -settle does. Defaults to the epoch size; smaller values give overlapping epochs.")
+	args.BoolVar(&dropPartial, "drop-partial", false, "Discard a trailing epoch shorter than the epoch length.")
+	args.IntVar(&timeColumn, "time-col", -1, "0-based index of a timestamp column in seconds. The sample rate is then measured from the timestamps.")
+	args.StringVar(&dir, "dir", "", "Calibrate every input file in this directory separately, e.g. every .csv and .csv.gz file.")
+	args.BoolVar(&showVersion, "version", false, "Print the version and exit.")
+	args.StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn or error. Corrections in the log output format are logged at info.")
+	args.StringVar(&logFormat, "log-format", "text", "Log format: text or json.")
+	args.BoolVar(&strict, "strict", false, "Exit on inconsistencies that are otherwise only logged as warnings, such as epochs of unexpected length or implausible corrections.")
+	args.StringVar(&units, "units", string(acc.UnitMS2), "Unit of the input, of -t and of the reported offsets: ms2 (m/s²) or g (g-units).")
+	args.BoolVar(&stats, "stats", false, "Write per-epoch mean, SD and norm as CSV and summarize the SDs without calibrating.")
+	args.StringVar(&inputFormat, "format", "csv", "Input format: csv, json (newline-delimited objects) or bin (packed X, Y and Z samples, see -bin-spec).")
+	args.StringVar(&jsonKeys, "json-keys", "x,y,z", "Comma-separated keys of the X, Y and Z values in JSON input, optionally followed by a timestamp key and a temperature key. The timestamp key may be empty.")
+	args.StringVar(&apply, "apply", "", "Apply the corrections in this JSON file instead of fitting new ones, writing the corrected records to -out or stdout.")
+	args.StringVar(&weightNorm, "weight-norm", string(acc.NormOfMean), "Norm by which ICP weights epochs: norm-of-mean (opposing transients cancel) or mean-of-norms (transients always lower the weight).")
+	args.IntVar(&histBins, "hist", 0, "Print histograms with this many bins of the epoch residuals ||acc|| - g before and after correction to stderr.")
+	args.StringVar(&cols, "cols", "", "Comma-separated 0-based indices of the X, Y and Z columns, e.g. 5,6,7. Defaults to the first three columns other than -time-col and -temp-col.")
+	args.IntVar(&tempColumn, "temp-col", -1, "0-based index of a sensor temperature column. The offsets are then fitted as linear functions of the temperature.")
+	args.StringVar(&comment, "comment", "#", "Ignore CSV lines starting with this character. An empty value disables comments.")
+	args.Float64Var(&maxAbs, "max-abs", 0, "Reject rows with an axis value larger than this in magnitude, in -units. NaN and infinite values are always rejected. Not checked when zero.")
+	args.StringVar(&reportPath, "report", "", "Write a JSON report with record and epoch counts, the SD distribution, the corrections and the diagnostics to this file. With -dir, the report holds one entry per file.")
+	args.IntVar(&decimate, "decimate", 1, "Average every N consecutive records into one before calibrating, dividing the sample rate by N. Epochs keep their length in seconds and hold epoch * hz / N records. Corrected records are written at the full rate.")
+	args.IntVar(&orientations, "orientations", 0, "Expected number of static orientations, e.g. 6 for +-X, +-Y and +-Z up. Stationary epochs are then merged per orientation before fitting. Disabled when zero.")
+	args.IntVar(&maxRecords, "max-records", 0, "Stop reading the input after this many records. Unlimited when zero or negative.")
+	args.BoolVar(&showProgress, "progress", false, "Report the progress of computing epoch statistics and of ICP to stderr.")
+	args.IntVar(&folds, "cv", 0, "Cross-validate with this many folds of the retained epochs, reporting the RMSE on the held-out epochs. Disabled when zero.")
+	args.IntVar(&smooth, "smooth", 1, "Smooth each axis with a moving average over N records before epoching. Smoothing lowers the epoch SDs, by about sqrt(N) for white noise, so -t must be lowered accordingly. No smoothing when N <= 1.")
+	args.Float64Var(&sigma, "sigma", 0, "Trim records more than this many SDs from their epoch's mean before the SD test. Not trimmed when zero.")
+	args.Int64Var(&seed, "seed", 0, "Seed of randomized steps, logged and included in the -report so that a run can be reproduced. No step is randomized yet, so the seed does not change the results.")
+	args.BoolVar(&allan, "allan", false, "Write the overlapping Allan deviation of each axis at averaging times of 1, 2, 4, ... records as CSV without calibrating.")
+	args.StringVar(&convergeOn, "converge-on", string(acc.ConvergeRMSE), "Quantity whose change between ICP iterations must fall below -tol: rmse (RMSE of the epoch norms from gravity, independent of the parameters' scales) or param (largest change of any offset or gain).")
+	args.BoolVar(&normalize, "normalize", false, "Subtract the mean of each axis across all records of a file before epoching, to show the variation around it. This removes gravity along with the offsets, so it only applies to -stats and cannot be used to calibrate.")
+	args.IntVar(&orientationColumn, "orientation-col", -1, "0-based index of a CSV column labelling the orientation of each record: +x, -x, +y, -y, +z or -z for the axis pointing up, or empty if unknown. ICP fits epochs whose records share a label to the reading expected in that orientation instead of only to the gravity norm.")
+	args.BoolVar(&robust, "robust", false, "Test each epoch's per-axis median absolute deviation (MAD) against -t instead of its SD, which brief spikes affect less. The MAD of normally distributed noise is about 0.6745 times its SD, so -t must be lowered accordingly.")
+	args.BoolVar(&showTiming, "timing", false, "Log the wall-clock time and throughput of parsing, pre-processing and ICP, and the total time of the run.")
+	args.BoolVar(&allowMissing, "allow-missing", false, "Read empty X, Y or Z fields of CSV input as missing values instead of rejecting the row. Missing values are left out of their axis' epoch mean and SD.")
+	args.StringVar(&merge, "merge", "", "Average the corrections in these comma-separated JSON files, such as those of several devices of one type, and write them with their per-axis variance as JSON. The result can be passed to -apply.")
+	args.StringVar(&gainBounds, "gain-bounds", "0.8,1.2", "Plausible range of the fitted gains as MIN,MAX. Gains outside it are warned about, or fail the run with -strict. Not checked when empty.")
+	args.Float64Var(&maxOffset, "max-offset", 0.2, "Largest plausible magnitude of a fitted offset, as a fraction of -g. Larger offsets are warned about, or fail the run with -strict. Not checked when zero.")
+	args.BoolVar(&quiet, "quiet", false, "Log only errors, to stderr, so that stdout holds nothing but the machine-readable output. Requires an -o other than log when calibrating.")
+	args.IntVar(&stuckRun, "stuck-run", 0, "Warn about epochs holding a run of at least this many identical consecutive records, which a stuck sensor produces with an artificially zero SD. Not checked when zero.")
+	args.BoolVar(&dropStuck, "drop-stuck", false, "Discard the epochs found by -stuck-run instead of only warning.")
+	args.BoolVar(&overlapWeight, "overlap-weight", false, "Scale down the ICP weight of epochs that share records with other fitted epochs, by about -stride / epoch size, so overlapping epochs do not count the same records several times. Recorded in the -report.")
+	args.StringVar(&dumpEpochs, "dump-epochs", "", "Write the index, mean vector, SDs and norm of each retained stationary epoch as CSV in -units to this path. The index matches the -stats output, or is -1 for the epochs merged by -orientations. With -stats, the epochs are selected with -t without calibrating.")
+	args.StringVar(&binSpec, "bin-spec", "float32,le", "Layout of bin input as TYPE,ORDER[,SCALE]: sample type float32 or int16, byte order le or be, and the factor converting a sample to -units, e.g. int16,be,0.000122 for a 16-bit sensor at +-4 g. The scale defaults to 1.")
+	args.Float64Var(&maxCondition, "max-condition", acc.DefaultConfig().MaxCondition, "Warn when the condition number of the fit exceeds this, meaning the epochs span too few orientations to tell offsets from gains. Rank-deficient fits are always warned about. Not checked when zero.")
+	args.StringVar(&fitAxes, "axes", "XYZ", "Axes to calibrate, e.g. XY. The other axes are reported with offset 0 and gain 1, so that an axis with bad data does not distort the others.")
+	args.IntVar(&settle, "settle", 0, "Discard the first N records of each epoch, which may still be settling after the device was moved, before computing its statistics. N counts records after -decimate and must be less than the epoch size. With overlapping epochs, see -stride, the discarded records remain in the preceding epochs.")
+	args.StringVar(&inputRange, "range", "", "Plausible range of CSV axis values as MIN,MAX in -units, such as the range of the sensor. Rows with a value outside it are rejected, or skipped with -skip-bad-rows. Not checked when empty.")
+	args.BoolVar(&clamp, "clamp", false, "Set CSV axis values outside -range to the nearest bound instead of rejecting the row, logging how many were clamped.")
+	args.Float64Var(&maxGap, "gap", 0, "Start a new epoch after a gap of more than this many seconds between consecutive timestamps, so that no epoch spans two recording segments. Requires timestamps, see -time-col. Not split when zero.")
+	args.Parse(os.Args[1:])
+
+	if showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
+	level, err := log.ParseLevel(logLevel)
+	if err != nil {
+		exitWithUsage(args, "Unknown log level %q.", logLevel)
+	}
+	log.SetLevel(level)
+
+	switch logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		exitWithUsage(args, "Unknown log format %q.", logFormat)
+	}
+
+	if err := checkFlagCombinations(args); err != nil {
+		exitWithUsage(args, "%s", err)
+	}
+
+	if !validFormat(format) {
+		exitWithUsage(args, "Unknown output format %q.", format)
+	}
+
+	if quiet && format == "log" && !stats && !allan && apply == "" && merge == "" {
+		exitWithUsage(args, "-quiet would suppress the log output format, choose another -o.")
+	}
+
+	if format == "cheader" && dir != "" {
+		exitWithUsage(args, "-o cheader describes a single device and cannot be combined with -dir.")
+	}
+
+	if prec < 0 {
+		exitWithUsage(args, "-precision must not be negative.")
+	}
+
+	files := strings.Split(file, ",")
+	if len(files) > 1 {
+		for _, f := range files {
+			if f == "" || f == "-" {
+				exitWithUsage(args, "-f cannot list stdin or an empty path among several files.")
+			}
+		}
+		if apply != "" || allan {
+			exitWithUsage(args, "-apply and -allan take a single -f file.")
+		}
+	}
+
+	// Only calibrating and dumping epochs select stationary epochs by -t
+	selectsEpochs := (!stats && !allan && apply == "" && merge == "") || (stats && dumpEpochs != "")
+	thresholds, err := parseThresholds(threshold)
+	if err != nil && selectsEpochs {
+		exitWithUsage(args, "%s", err)
+	}
+
+	cfg := acc.DefaultConfig()
+	cfg.Iterations = iterations
+	cfg.Tolerance = tolerance
+	cfg.Strict = strict
+
+	if innerIterations < 1 {
+		exitWithUsage(args, "-inner-iter must be at least 1.")
+	}
+	cfg.InnerIterations = innerIterations
+
+	cfg.RecordsPerSecond = hz
+	cfg.EpochSeconds = epochSeconds
+
+	if histBins < 0 {
+		exitWithUsage(args, "The number of histogram bins must not be negative.")
+	}
+
+	if stride < 0 {
+		exitWithUsage(args, "The stride must not be negative.")
+	}
+	cfg.Stride = stride
+	cfg.OverlapWeighting = overlapWeight
+	cfg.DropPartial = dropPartial
+
+	cfg.Settle = settle
+
+	cfg.SampleSD = sampleSD
+
+	if workers <= 0 {
+		exitWithUsage(args, "The number of workers must be greater than zero.")
+	}
+	cfg.Workers = workers
+
+	if orientations < 0 {
+		exitWithUsage(args, "The number of orientations must not be negative.")
+	}
+	cfg.Orientations = orientations
+
+	if sigma < 0 {
+		exitWithUsage(args, "-sigma must not be negative.")
+	}
+	cfg.Sigma = sigma
+
+	if dropStuck && stuckRun == 0 {
+		exitWithUsage(args, "-drop-stuck requires -stuck-run.")
+	}
+	cfg.StuckRun = stuckRun
+	cfg.DropStuck = dropStuck
+	cfg.Robust = robust
+
+	cfg.Seed = seed
+
+	var prog *progress
+	if showProgress {
+		prog = newProgress()
+		cfg.Progress = prog.report
+	}
+
+	switch acc.NormKind(weightNorm) {
+	case acc.NormOfMean, acc.MeanOfNorms:
+		cfg.WeightNorm = acc.NormKind(weightNorm)
+	default:
+		exitWithUsage(args, "Unknown weight norm %q.", weightNorm)
+	}
+
+	cfg.Gravity = gravity
+
+	cfg.MinGain, cfg.MaxGain, err = parseGainBounds(gainBounds)
+	if err != nil {
+		exitWithUsage(args, "%s", err)
+	}
+
+	cfg.MaxOffset = maxOffset * gravity
+	cfg.MaxCondition = maxCondition
+
+	cfg.FixedAxes, err = parseAxes(fitAxes)
+	if err != nil {
+		exitWithUsage(args, "%s", err)
+	}
+
+	switch acc.ConvergenceKind(convergeOn) {
+	case acc.ConvergeRMSE, acc.ConvergeParam:
+		cfg.ConvergeOn = acc.ConvergenceKind(convergeOn)
+	default:
+		exitWithUsage(args, "Unknown convergence metric %q.", convergeOn)
+	}
+
+	switch acc.ModelKind(model) {
+	case acc.ModelSimple, acc.ModelFull:
+		cfg.Model = acc.ModelKind(model)
+	default:
+		exitWithUsage(args, "Unknown model %q.", model)
+	}
+
+	switch acc.Unit(units) {
+	case acc.UnitMS2, acc.UnitG:
+	default:
+		exitWithUsage(args, "Unknown units %q.", units)
+	}
+
+	// The thresholds are given in the input unit or relative to gravity,
+	// the records are in m/s²
+	for k, t := range thresholds {
+		cfg.Thresholds[k] = t.ms2(acc.Unit(units), cfg.Gravity)
+	}
+
+	// The thresholds were checked by parseThresholds when they are needed
+	checked := cfg
+	if !selectsEpochs {
+		checked.Thresholds = [3]float64{1, 1, 1}
+	}
+	if err := checked.Validate(); err != nil {
+		exitWithUsage(args, "%s.", err)
+	}
+
+	comma, err := parseDelimiter(delim)
+	if err != nil {
+		exitWithUsage(args, "%s", err)
+	}
+
+	if decimalComma && comma == ',' {
+		exitWithUsage(args, "-decimal-comma requires a delimiter other than ','.")
+	}
+
+	commentRune, err := parseComment(comment, comma)
+	if err != nil {
+		exitWithUsage(args, "%s", err)
+	}
+
+	if maxAbs < 0 {
+		exitWithUsage(args, "-max-abs must not be negative.")
+	}
+
+	if inputFormat != "csv" && inputFormat != "json" && inputFormat != "bin" {
+		exitWithUsage(args, "Unknown input format %q.", inputFormat)
+	}
+
+	keys := strings.Split(jsonKeys, ",")
+	if len(keys) < 3 || len(keys) > 5 {
+		exitWithUsage(args, "-json-keys must list the X, Y and Z keys and optionally timestamp and temperature keys.")
+	}
+	jsonOpts := acc.JSONOptions{
+		XKey:        keys[0],
+		YKey:        keys[1],
+		ZKey:        keys[2],
+		SkipBadRows: skipBadRows,
+		MaxAbs:      maxAbs,
+		MaxRecords:  maxRecords,
+		Units:       acc.Unit(units),
+	}
+	if len(keys) >= 4 {
+		jsonOpts.TimeKey = keys[3]
+	}
+	if len(keys) == 5 {
+		jsonOpts.TempKey = keys[4]
+	}
+
+	binOpts, err := parseBinSpec(binSpec)
+	if err != nil {
+		exitWithUsage(args, "%s", err)
+	}
+	binOpts.SkipBadRows = skipBadRows
+	binOpts.MaxAbs = maxAbs
+	binOpts.MaxRecords = maxRecords
+	binOpts.Units = acc.Unit(units)
+
+	if tempColumn >= 0 && tempColumn == timeColumn {
+		exitWithUsage(args, "-temp-col and -time-col must differ.")
+	}
+
+	if orientationColumn >= 0 && (orientationColumn == timeColumn || orientationColumn == tempColumn) {
+		exitWithUsage(args, "-orientation-col must differ from -time-col and -temp-col.")
+	}
+
+	if orientationColumn >= 0 && inputFormat != "csv" {
+		exitWithUsage(args, "-orientation-col only applies to CSV input.")
+	}
+
+	columns, err := parseColumns(cols, timeColumn, tempColumn, orientationColumn)
+	if err != nil {
+		exitWithUsage(args, "%s", err)
+	}
+
+	rangeMin, rangeMax, err := parseRange(inputRange)
+	if err != nil {
+		exitWithUsage(args, "%s", err)
+	}
+	if (inputRange != "" || clamp) && inputFormat != "csv" {
+		exitWithUsage(args, "-range and -clamp only apply to CSV input.")
+	}
+	if clamp && inputRange == "" {
+		exitWithUsage(args, "-clamp requires -range.")
+	}
+
+	opts := acc.CSVOptions{
+		Header:       header,
+		Comma:        comma,
+		Comment:      commentRune,
+		DecimalComma: decimalComma,
+		SkipBadRows:  skipBadRows,
+		AllowMissing: allowMissing,
+		MaxAbs:       maxAbs,
+		MaxRecords:   maxRecords,
+		Columns:      columns,
+		ParseTime:    timeColumn >= 0,
+		TimeColumn:   timeColumn,
+		ParseTemp:    tempColumn >= 0,
+		TempColumn:   tempColumn,
+
+		ParseOrientation:  orientationColumn >= 0,
+		OrientationColumn: orientationColumn,
+		Units:             acc.Unit(units),
+
+		RangeMin: rangeMin,
+		RangeMax: rangeMax,
+		Clamp:    clamp,
+	}
+
+	p := &pipeline{
+		format:   inputFormat,
+		opts:     opts,
+		jsonOpts: jsonOpts,
+		binOpts:  binOpts,
+		cfg:      cfg,
+		progress: prog,
+	}
+
+	if folds < 0 || folds == 1 {
+		exitWithUsage(args, "-cv needs at least 2 folds.")
+	}
+	p.folds = folds
+	p.smooth = smooth
+	p.showTiming = showTiming
+
+	// JSON and CSV keep full precision unless -precision is given
+	p.prec = precision{text: prec, data: -1}
+	args.Visit(func(f *flag.Flag) {
+		if f.Name == "precision" {
+			p.prec.data = prec
+		}
+	})
+
+	if normalize && !stats {
+		exitWithUsage(args, "-normalize removes gravity from the records and only applies to -stats.")
+	}
+	p.normalize = normalize
+
+	if maxGap > 0 && !p.hasTime() {
+		exitWithUsage(args, "-gap requires timestamps, see -time-col and -json-keys.")
+	}
+	p.cfg.MaxGap = maxGap
+	p.cfg.TempCompensation = p.hasTemp()
+
+	if decimate < 1 {
+		exitWithUsage(args, "-decimate must be at least 1.")
+	}
+	p.decimate = decimate
+
+	// Invalid flags are still reported above
+	if quiet {
+		log.SetLevel(log.ErrorLevel)
+	}
+	log.Infof("Using seed %d", seed)
+
+	if merge != "" {
+		if err := runMerge(os.Stdout, merge, p.prec.data); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	ctx := interruptContext()
+
+	if dir != "" {
+		runBatch(ctx, p, dir, inputFormat, format, reportPath)
+		if showTiming {
+			log.Printf("Total time: %s\n", time.Since(start))
+		}
+		return
+	}
+
+	if apply != "" {
+		if out == "" {
+			out = "-"
+		}
+		if err := p.applyFile(file, apply, out); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	if allan {
+		if err := p.runAllan(os.Stdout, file); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	if stats {
+		if err := p.runStats(ctx, os.Stdout, files, dumpEpochs); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	res, err := p.calibrateFiles(ctx, files)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Warnln("Interrupted before the calibration finished, no results to write")
+			os.Exit(130)
+		}
+		log.Fatal(err.Error())
+	}
+
+	if err := writeResult(os.Stdout, format, res, p.prec); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if histBins > 0 {
+		writeHistograms(os.Stderr, res, histBins, opts.Units, cfg.Gravity, p.prec.text)
+	}
+
+	if dumpEpochs != "" {
+		if err := p.writeEpochsFile(dumpEpochs, res.epochs); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if reportPath != "" {
+		if err := writeReportFile(reportPath, newReport(res, p.cfg, opts.Units), p.prec.data); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if out != "" {
+		corrected := acc.ApplyCorrections(res.records, res.corrections)
+		if err := writeRecordsFile(out, acc.ConvertRecords(corrected, opts.Units), p.prec.data); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if showTiming {
+		logTiming(res)
+		log.Printf("Total time: %s\n", time.Since(start))
+	}
+}
+
+// Logs the problem with the command line given like fmt.Printf, prints the
+// usage of args and exits
+func exitWithUsage(args *flag.FlagSet, format string, v ...interface{}) {
+	log.Warnf(format+" Exiting.", v...)
+	args.PrintDefaults()
+	os.Exit(1)
+}
+
+// Calibrates every input file in dir, continuing past files that fail, and
+// writes their reports to reportPath unless it is empty. If ctx is cancelled
+// the files calibrated so far are written before exiting.
+func runBatch(ctx context.Context, p *pipeline, dir, inputFormat, format, reportPath string) {
+	files, err := inputFiles(dir, inputFormat)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(files) == 0 {
+		log.Fatalf("No %s files found in %s", inputFormat, dir)
+	}
+
+	results := make([]*fileResult, 0, len(files))
+	failed := 0
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		log.Infof("Calibrating %s", file)
+
+		res, err := p.calibrateFiles(ctx, []string{file})
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Errorf("Skipping %s: %s", file, err.Error())
+			failed++
+			continue
+		}
+
+		if p.showTiming {
+			logTiming(res)
+		}
+
+		results = append(results, res)
+	}
+
+	if err := writeBatch(os.Stdout, format, results, p.prec); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if reportPath != "" {
+		reports := make([]*report, 0, len(results))
+		for _, res := range results {
+			reports = append(reports, newReport(res, p.cfg, p.opts.Units))
+		}
+		if err := writeReportFile(reportPath, reports, p.prec.data); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if failed > 0 {
+		log.Warnf("%d of %d files failed", failed, len(files))
+	}
+
+	if ctx.Err() != nil {
+		log.Warnf("Interrupted: the results are partial and cover %d of %d files", len(results), len(files))
+		os.Exit(130)
+	}
+}
+
+// Returns a context that is cancelled on the first SIGINT. A second SIGINT
+// terminates the process immediately.
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Warnln("Interrupt received, finishing with partial results. Interrupt again to abort.")
+	}()
+
+	return ctx
+}
+
+// A threshold given on the command line
+type threshold struct {
+	value float64
+
+	// value is a percentage of gravity rather than in the input unit
+	percent bool
+}
+
+// Returns the threshold in m/s², given the input unit u and the magnitude
+// of gravity in m/s²
+func (t threshold) ms2(u acc.Unit, gravity float64) float64 {
+	if t.percent {
+		return t.value / 100 * gravity
+	}
+
+	return acc.ConvertToMS2(t.value, u)
+}
+
+// Returns the X, Y and Z thresholds given on the command line as either a
+// single value or three comma-separated values, each of which may be a
+// percentage of gravity such as 1%
+func parseThresholds(s string) ([3]threshold, error) {
+	var thresholds [3]threshold
+
+	fields := strings.Split(s, ",")
+	if len(fields) != 1 && len(fields) != 3 {
+		return thresholds, fmt.Errorf("Threshold must be one value or three comma-separated values, got %q.", s)
+	}
+
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+		percent := strings.HasSuffix(field, "%")
+		t, err := strconv.ParseFloat(strings.TrimSuffix(field, "%"), 64)
+		if err != nil || t <= 0 {
+			return thresholds, errors.New("Threshold must be a positive floating point number or percentage of -g.")
+		}
+		thresholds[i] = threshold{value: t, percent: percent}
+	}
+
+	if len(fields) == 1 {
+		thresholds[1], thresholds[2] = thresholds[0], thresholds[0]
+	}
+
+	return thresholds, nil
+}
+
+// Flags that each mode ignores, so that setting them together is a mistake
+var conflictingFlags = []struct {
+	mode  string
+	flags []string
+}{
+	{"dir", []string{"f", "out", "stats", "apply", "allan", "dump-epochs", "hist"}},
+	{"apply", []string{"dump-epochs", "t", "tol", "stats", "cv", "hist", "report", "orientations", "sigma", "stuck-run", "drop-stuck", "overlap-weight", "o", "allan"}},
+	{"stats", []string{"out", "tol", "cv", "hist", "report", "orientations", "o", "allan"}},
+	{"allan", []string{"dump-epochs", "t", "tol", "out", "cv", "hist", "report", "orientations", "sigma", "stuck-run", "drop-stuck", "overlap-weight", "o"}},
+	{"quiet", []string{"log-level", "progress", "timing"}},
+	{"merge", []string{"f", "dir", "apply", "stats", "allan", "dump-epochs", "t", "tol", "out", "cv", "hist", "report", "o"}},
+}
+
+// Returns an error naming the first pair of flags set on the command line
+// that contradict each other
+func checkFlagCombinations(args *flag.FlagSet) error {
+	set := make(map[string]bool)
+	args.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for _, c := range conflictingFlags {
+		if !set[c.mode] {
+			continue
+		}
+		for _, f := range c.flags {
+			if set[f] {
+				return fmt.Errorf("-%s cannot be combined with -%s.", c.mode, f)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the smallest and largest plausible gain given as MIN,MAX, or zeros
+// if s is empty
+func parseGainBounds(s string) (float64, float64, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	fields := strings.Split(s, ",")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("-gain-bounds must be two comma-separated values, got %q.", s)
+	}
+
+	min, errMin := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	max, errMax := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if errMin != nil || errMax != nil || min < 0 || max < min {
+		return 0, 0, fmt.Errorf("-gain-bounds must be two non-negative values with MIN <= MAX, got %q.", s)
+	}
+
+	return min, max, nil
+}
+
+// Returns which of the X, Y and Z axes are left out of the axes to
+// calibrate given on the command line, such as XY
+func parseAxes(s string) ([3]bool, error) {
+	fixed := [3]bool{true, true, true}
+	for _, c := range strings.ToUpper(s) {
+		k := strings.IndexRune("XYZ", c)
+		if k < 0 {
+			return fixed, fmt.Errorf("-axes must only hold the letters X, Y and Z, got %q.", s)
+		}
+		fixed[k] = false
+	}
+
+	if fixed[0] && fixed[1] && fixed[2] {
+		return fixed, errors.New("-axes must select at least one axis.")
+	}
+
+	return fixed, nil
+}
+
+// Returns the binary input options given as TYPE,ORDER[,SCALE] on the
+// command line
+func parseBinSpec(s string) (acc.BinaryOptions, error) {
+	var opts acc.BinaryOptions
+
+	fields := strings.Split(s, ",")
+	if len(fields) != 2 && len(fields) != 3 {
+		return opts, fmt.Errorf("-bin-spec must be TYPE,ORDER or TYPE,ORDER,SCALE, got %q.", s)
+	}
+
+	switch t := acc.SampleType(strings.TrimSpace(fields[0])); t {
+	case acc.SampleFloat32, acc.SampleInt16:
+		opts.Sample = t
+	default:
+		return opts, fmt.Errorf("-bin-spec sample type must be float32 or int16, got %q.", fields[0])
+	}
+
+	switch strings.TrimSpace(fields[1]) {
+	case "le":
+	case "be":
+		opts.BigEndian = true
+	default:
+		return opts, fmt.Errorf("-bin-spec byte order must be le or be, got %q.", fields[1])
+	}
+
+	if len(fields) == 3 {
+		scale, err := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+		if err != nil || scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+			return opts, fmt.Errorf("-bin-spec scale must be a non-zero number, got %q.", fields[2])
+		}
+		opts.Scale = scale
+	}
+
+	return opts, nil
+}
+
+// Returns the plausible range of axis values given as MIN,MAX, or zeros if s
+// is empty
+func parseRange(s string) (float64, float64, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	fields := strings.Split(s, ",")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("-range must be two comma-separated values, got %q.", s)
+	}
+
+	min, errMin := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+	max, errMax := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+	if errMin != nil || errMax != nil || !(min < max) {
+		return 0, 0, fmt.Errorf("-range must be two numbers with MIN < MAX, got %q.", s)
+	}
+
+	return min, max, nil
+}
+
+// Returns the X, Y and Z column indices given on the command line, or nil if
+// none were given. They must not overlap the reserved timestamp and
+// temperature columns.
+func parseColumns(s string, reserved ...int) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("-cols must list exactly three column indices, got %q.", s)
+	}
+
+	columns := make([]int, 0, 3)
+	seen := make(map[int]bool)
+	for _, col := range reserved {
+		seen[col] = true
+	}
+	for _, field := range fields {
+		col, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || col < 0 {
+			return nil, fmt.Errorf("Invalid column index %q.", field)
+		}
+		if seen[col] {
+			return nil, fmt.Errorf("Column %d is used more than once.", col)
+		}
+		seen[col] = true
+		columns = append(columns, col)
+	}
+
+	return columns, nil
+}
+
+// Returns the delimiter rune given on the command line. The escape
+// sequence \t is accepted for tab-separated files.
+func parseDelimiter(delim string) (rune, error) {
+	if delim == "\\t" {
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(delim)
+	if r == utf8.RuneError || size != len(delim) {
+		return 0, fmt.Errorf("Delimiter must be a single character, got %q.", delim)
+	}
+
+	if r == '\r' || r == '\n' || r == '"' {
+		return 0, fmt.Errorf("Delimiter %q is not allowed.", delim)
+	}
+
+	return r, nil
+}
+
+// Returns the comment rune given on the command line, or zero if comments
+// are disabled. The rune must differ from the delimiter comma.
+func parseComment(comment string, comma rune) (rune, error) {
+	if comment == "" {
+		return 0, nil
+	}
+
+	r, size := utf8.DecodeRuneInString(comment)
+	if r == utf8.RuneError || size != len(comment) {
+		return 0, fmt.Errorf("Comment must be a single character, got %q.", comment)
+	}
+
+	if r == comma {
+		return 0, fmt.Errorf("Comment character %q is also the delimiter.", comment)
+	}
+
+	if r == '\r' || r == '\n' || r == '"' {
+		return 0, fmt.Errorf("Comment character %q is not allowed.", comment)
+	}
+
+	return r, nil
+}
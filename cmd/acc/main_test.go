@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tomcat-bit/acc"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden files in testdata")
+
+// Runs the command instead of the tests when the test binary is re-executed
+// with ACC_RUN_MAIN set, so that its output can be compared
+func TestMain(m *testing.M) {
+	if os.Getenv("ACC_RUN_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Returns a flag set defining every flag named in conflictingFlags, parsed
+// from the arguments. Values are ignored, so all flags take strings.
+func parseFlags(t *testing.T, arguments ...string) *flag.FlagSet {
+	args := flag.NewFlagSet("args", flag.ContinueOnError)
+	args.SetOutput(io.Discard)
+
+	defined := make(map[string]bool)
+	define := func(name string) {
+		if !defined[name] {
+			args.String(name, "", "")
+			defined[name] = true
+		}
+	}
+	for _, c := range conflictingFlags {
+		define(c.mode)
+		for _, f := range c.flags {
+			define(f)
+		}
+	}
+
+	if err := args.Parse(arguments); err != nil {
+		t.Fatal(err)
+	}
+
+	return args
+}
+
+func TestCheckFlagCombinations(t *testing.T) {
+	tests := []struct {
+		arguments []string
+
+		// Substring of the error, empty if the combination is valid
+		want string
+	}{
+		{[]string{"-t", "0.01"}, ""},
+		{[]string{"-stats", "1", "-t", "0.01", "-dump-epochs", "e.csv"}, ""},
+		{[]string{"-apply", "c.json", "-out", "o.csv"}, ""},
+		{[]string{"-dir", "d", "-report", "r.json", "-o", "json"}, ""},
+		{[]string{"-apply", "c.json", "-t", "0.01"}, "-apply cannot be combined with -t"},
+		{[]string{"-stats", "1", "-out", "o.csv"}, "-stats cannot be combined with -out"},
+		{[]string{"-dir", "d", "-f", "in.csv"}, "-dir cannot be combined with -f"},
+		{[]string{"-dir", "d", "-hist", "20"}, "-dir cannot be combined with -hist"},
+		{[]string{"-allan", "1", "-t", "0.01"}, "-allan cannot be combined with -t"},
+		{[]string{"-stats", "1", "-tol", "1e-9"}, "-stats cannot be combined with -tol"},
+		{[]string{"-quiet", "1", "-log-level", "debug"}, "-quiet cannot be combined with -log-level"},
+		{[]string{"-merge", "a.json,b.json", "-apply", "c.json"}, "-merge cannot be combined with -apply"},
+		{[]string{"-merge", "a.json,b.json", "-o", "json"}, "-merge cannot be combined with -o"},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.arguments, " "), func(t *testing.T) {
+			err := checkFlagCombinations(parseFlags(t, test.arguments...))
+			if test.want == "" {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// Every pair of flags in the table is rejected, whatever the order on the
+// command line
+func TestCheckFlagCombinationsTable(t *testing.T) {
+	for _, c := range conflictingFlags {
+		for _, f := range c.flags {
+			for _, arguments := range [][]string{{"-" + c.mode, "x", "-" + f, "y"}, {"-" + f, "y", "-" + c.mode, "x"}} {
+				if err := checkFlagCombinations(parseFlags(t, arguments...)); err == nil {
+					t.Errorf("%s was accepted", strings.Join(arguments, " "))
+				}
+			}
+		}
+	}
+}
+
+// Calibrates the synthetic recording of the library tests, a sensor with
+// offsets (0.3, -0.2, 0.15) and gains (1.05, 0.97, 1.02), and compares the
+// output with the golden files. Run with -update to rewrite them.
+func TestGolden(t *testing.T) {
+	input := filepath.Join("..", "..", "testdata", "synthetic.csv")
+	for _, format := range []string{"json", "table"} {
+		t.Run(format, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-f", input, "-hz", "10", "-epoch", "5", "-t", "0.05", "-o", format, "-precision", "6", "-quiet")
+			cmd.Env = append(os.Environ(), "ACC_RUN_MAIN=1")
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			got, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%v: %s", err, stderr.String())
+			}
+
+			golden := filepath.Join("testdata", "synthetic."+format)
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+// Corrections written with -o json, as a result or as a bare array, read
+// back and applied give the values of the fitted model
+func TestJSONCorrectionsRoundTrip(t *testing.T) {
+	simple := []*acc.Correction{
+		{Axis: 'X', Offset: 0.3, Gain: 1.05},
+		{Axis: 'Y', Offset: -0.2, Gain: 0.97},
+		{Axis: 'Z', Offset: 0.15, Gain: 1.02},
+	}
+	full := []*acc.Correction{
+		{Axis: 'X', Offset: 0.3, Gain: 1.05, Matrix: []float64{1.05, 0, 0}},
+		{Axis: 'Y', Offset: -0.2, Gain: 0.97, Matrix: []float64{0.02, 0.97, 0}},
+		{Axis: 'Z', Offset: 0.15, Gain: 1.02, Matrix: []float64{-0.01, 0.03, 1.02}},
+	}
+	tempCompensated := []*acc.Correction{
+		{Axis: 'X', Offset: 0.3, Gain: 1.05, TempSlope: 0.004, RefTemp: 25},
+		{Axis: 'Y', Offset: -0.2, Gain: 0.97, TempSlope: -0.002, RefTemp: 25},
+		{Axis: 'Z', Offset: 0.15, Gain: 1.02, TempSlope: 0.001, RefTemp: 25},
+	}
+
+	raw := &acc.Record{AccX: 1, AccY: 2, AccZ: 3, Temp: 30}
+	tests := []struct {
+		name        string
+		corrections []*acc.Correction
+		want        [3]float64
+	}{
+		{"simple", simple, [3]float64{1.05 + 0.3, 1.94 - 0.2, 3.06 + 0.15}},
+		{"matrix", full, [3]float64{1.05 + 0.3, 0.02 + 1.94 - 0.2, -0.01 + 0.06 + 3.06 + 0.15}},
+		{"temperature slopes", tempCompensated, [3]float64{1.05 + 0.3 + 0.02, 1.94 - 0.2 - 0.01, 3.06 + 0.15 + 0.005}},
+	}
+
+	for _, test := range tests {
+		shapes := map[string]interface{}{
+			"result":     &fileResult{File: "in.csv", Corrections: test.corrections, Diagnostics: &acc.Diagnostics{}},
+			"bare array": test.corrections,
+		}
+		for shape, v := range shapes {
+			t.Run(test.name+" "+shape, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := writeJSON(&buf, v, 6); err != nil {
+					t.Fatal(err)
+				}
+
+				corrections, err := acc.ReadCorrections(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := acc.ApplyCorrections([]*acc.Record{raw}, corrections)[0]
+				for k, got := range [3]float64{r.AccX, r.AccY, r.AccZ} {
+					if math.Abs(got-test.want[k]) > 1e-9 {
+						t.Errorf("axis %c: got %g, want %g", "XYZ"[k], got, test.want[k])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestReadCorrectionsIncomplete(t *testing.T) {
+	tests := []struct {
+		name        string
+		corrections []*acc.Correction
+	}{
+		{"missing axis", []*acc.Correction{{Axis: 'X', Gain: 1}, {Axis: 'Y', Gain: 1}}},
+		{"duplicate axis", []*acc.Correction{{Axis: 'X', Gain: 1}, {Axis: 'Y', Gain: 1}, {Axis: 'Y', Gain: 1}}},
+		{"unknown axis", []*acc.Correction{{Axis: 'X', Gain: 1}, {Axis: 'Y', Gain: 1}, {Axis: 'W', Gain: 1}}},
+		{"short matrix row", []*acc.Correction{{Axis: 'X', Gain: 1}, {Axis: 'Y', Gain: 1}, {Axis: 'Z', Gain: 1, Matrix: []float64{0, 1}}}},
+		{"none", []*acc.Correction{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeJSON(&buf, &fileResult{Corrections: test.corrections, Diagnostics: &acc.Diagnostics{}}, 6); err != nil {
+				t.Fatal(err)
+			}
+
+			corrections, err := acc.ReadCorrections(&buf)
+			if !errors.Is(err, acc.ErrInvalidCorrections) {
+				t.Errorf("got error %v, want ErrInvalidCorrections", err)
+			}
+			if corrections != nil {
+				t.Errorf("got %d corrections, want none", len(corrections))
+			}
+		})
+	}
+}
+
+// Writes the rows to a file in a temporary directory and returns its path
+func writeInput(t *testing.T, name string, rows ...string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(strings.Join(rows, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Returns n copies of row
+func repeatRow(row string, n int) []string {
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = row
+	}
+
+	return rows
+}
+
+func newTestPipeline(epochSeconds float64) *pipeline {
+	cfg := acc.DefaultConfig()
+	cfg.RecordsPerSecond = 1
+	cfg.EpochSeconds = epochSeconds
+
+	return &pipeline{format: "csv", cfg: cfg, decimate: 1, smooth: 1}
+}
+
+// The 25 records of each file make two full epochs and a partial one, which
+// would span the boundary if the files were concatenated
+func TestLoadEpochsFileBoundary(t *testing.T) {
+	first := writeInput(t, "first.csv", repeatRow("1,2,3", 25)...)
+	second := writeInput(t, "second.csv", repeatRow("4,5,6", 25)...)
+
+	records, epochs, _, err := newTestPipeline(10).loadEpochs([]string{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 50 {
+		t.Errorf("got %d records, want 50", len(records))
+	}
+
+	fromSecond := 0
+	for i, e := range epochs {
+		if e.Index != i {
+			t.Errorf("epoch %d has index %d", i, e.Index)
+		}
+		if len(e.Records) == 0 {
+			continue
+		}
+		if e.Records[0].AccX == 4 {
+			fromSecond++
+		}
+		for _, r := range e.Records {
+			if r.AccX != e.Records[0].AccX {
+				t.Errorf("epoch %d spans both files", e.Index)
+				break
+			}
+		}
+	}
+	if fromSecond == 0 || fromSecond == len(epochs) {
+		t.Errorf("%d of %d epochs are from the second file", fromSecond, len(epochs))
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   [2]string
+		wantOK bool
+	}{
+		{"same columns", [2]string{"1,2,3", "4,5,6"}, true},
+		{"extra column", [2]string{"1,2,3", "4,5,6,7"}, false},
+		{"missing column", [2]string{"0,1,2,3", "4,5,6"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first := writeInput(t, "first.csv", repeatRow(test.rows[0], 10)...)
+			second := writeInput(t, "second.csv", repeatRow(test.rows[1], 10)...)
+
+			p := newTestPipeline(5)
+			err := p.checkLayout([]string{first, second})
+			if test.wantOK && err != nil {
+				t.Errorf("got error %v, want none", err)
+			}
+			if !test.wantOK {
+				if err == nil || !strings.Contains(err.Error(), "columns") {
+					t.Errorf("got error %v, want one naming the column counts", err)
+				}
+				if _, _, _, err := p.loadEpochs([]string{first, second}); err == nil {
+					t.Error("loadEpochs accepted files with different column counts")
+				}
+			}
+		})
+	}
+}
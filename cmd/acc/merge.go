@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/tomcat-bit/acc"
+)
+
+// Averaged corrections of several devices. The file can be passed to -apply.
+type mergedCorrections struct {
+	Devices     int                     `json:"devices"`
+	Corrections []*acc.Correction       `json:"corrections"`
+	Variance    []*acc.CorrectionSpread `json:"variance"`
+}
+
+// Merges the corrections in the comma-separated JSON files and writes the
+// result as JSON with p decimal places, or as many as needed if p is
+// negative, to w
+func runMerge(w io.Writer, files string, p int) error {
+	paths := strings.Split(files, ",")
+	sets := make([][]*acc.Correction, 0, len(paths))
+	for _, path := range paths {
+		corrections, err := acc.ReadCorrectionsFile(path)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, corrections)
+	}
+
+	merged, spreads, err := acc.MergeCorrections(sets)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, &mergedCorrections{
+		Devices:     len(sets),
+		Corrections: merged,
+		Variance:    spreads,
+	}, p)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tomcat-bit/acc"
+)
+
+var formats = []string{"log", "table", "json", "cheader"}
+
+func validFormat(format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Number of decimal places of numeric output
+type precision struct {
+	// Of output meant for reading: the log and C header formats, tables and
+	// histograms
+	text int
+
+	// Of JSON and CSV output. Negative for the fewest digits that read
+	// back to the same value.
+	data int
+}
+
+// Writes the result to w in the given output format. The log format goes
+// through the logger and ignores w.
+func writeResult(w io.Writer, format string, res *fileResult, prec precision) error {
+	switch format {
+	case "log":
+		logResult(res, prec.text)
+	case "json":
+		return writeJSON(w, res, prec.data)
+	case "cheader":
+		return writeCHeader(w, res, prec.text)
+	case "table":
+		return writeTable(w, res, prec.text)
+	default:
+		return fmt.Errorf("Unknown output format %s", format)
+	}
+
+	return nil
+}
+
+// Writes the corrections as an aligned table with one row per axis and p
+// decimal places, followed by the diagnostics
+func writeTable(w io.Writer, res *fileResult, p int) error {
+	hasMatrix := len(res.Corrections) > 0 && res.Corrections[0].Matrix != nil
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "Axis\tOffset\tGain")
+	if hasMatrix {
+		fmt.Fprint(tw, "\tMatrix X\tMatrix Y\tMatrix Z")
+	}
+	if res.tempCompensated {
+		fmt.Fprint(tw, "\tTemp slope\tRef temp")
+	}
+	fmt.Fprintln(tw, "\tNaive offset\t")
+
+	for k, r := range res.Corrections {
+		fmt.Fprintf(tw, "%c\t%.*f\t%.*f", r.Axis, p, r.Offset, p, r.Gain)
+		if hasMatrix {
+			fmt.Fprintf(tw, "\t%.*f\t%.*f\t%.*f", p, r.Matrix[0], p, r.Matrix[1], p, r.Matrix[2])
+		}
+		if res.tempCompensated {
+			fmt.Fprintf(tw, "\t%.*f\t%.*f", p, r.TempSlope, p, r.RefTemp)
+		}
+		fmt.Fprintf(tw, "\t%.*f\t\n", p, res.NaiveOffsets[k])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	diag := res.Diagnostics
+	fmt.Fprintf(w, "\nRMSE %.*f, %d iterations, converged: %t, condition number %.*f\n",
+		p, diag.RMSE, diag.Iterations, diag.Converged, p, diag.Condition)
+	if cv := res.CrossValidation; cv != nil {
+		_, err := fmt.Fprintf(w, "Cross-validated RMSE over %d folds: mean %.*f, SD %.*f\n", len(cv.Folds), p, cv.Mean, p, cv.SD)
+		return err
+	}
+
+	return nil
+}
+
+// Logs the result with p decimal places
+func logResult(res *fileResult, p int) {
+	for _, r := range res.Corrections {
+		log.Printf("Axis: %c\tOffset d: %.*f\tGain factor a: %.*f\n", r.Axis, p, r.Offset, p, r.Gain)
+	}
+	if len(res.Corrections) > 0 && res.Corrections[0].Matrix != nil {
+		log.Println("Scale and misalignment matrix:")
+		for _, r := range res.Corrections {
+			log.Printf("%c\t% .*f\t% .*f\t% .*f\n", r.Axis, p, r.Matrix[0], p, r.Matrix[1], p, r.Matrix[2])
+		}
+	}
+	if res.tempCompensated {
+		for _, r := range res.Corrections {
+			log.Printf("Axis: %c\tTemperature slope of d: %.*f\tReference temperature: %.*f\n", r.Axis, p, r.TempSlope, p, r.RefTemp)
+		}
+	}
+
+	for k, r := range res.Corrections {
+		log.Printf("Axis: %c\tNaive offset d: %.*f\tDifference to ICP: %.*f\n", r.Axis, p, res.NaiveOffsets[k], p, r.Offset-res.NaiveOffsets[k])
+	}
+
+	if cv := res.CrossValidation; cv != nil {
+		log.Printf("Cross-validated RMSE over %d folds: mean %.*f\tSD %.*f\n", len(cv.Folds), p, cv.Mean, p, cv.SD)
+	}
+
+	diag := res.Diagnostics
+	log.Printf("RMSE: %.*f\tIterations: %d\tConverged: %t\n", p, diag.RMSE, diag.Iterations, diag.Converged)
+	log.Printf("Condition number: %.*f\tRank: %d\n", p, diag.Condition, diag.Rank)
+}
+
+// Logs the time each phase of the calibration took and its throughput
+func logTiming(res *fileResult) {
+	t := res.timing
+	log.Printf("Parsed %d records in %s (%.0f records/s)\n", len(res.records), t.parse, perSecond(len(res.records), t.parse))
+	log.Printf("Pre-processed %d epochs in %s (%.0f epochs/s)\n", len(res.allEpochs), t.preProcess, perSecond(len(res.allEpochs), t.preProcess))
+	log.Printf("Ran %d ICP iterations in %s\n", res.Diagnostics.Iterations, t.icp)
+}
+
+// Returns n per second of d, or zero if d is zero
+func perSecond(n int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return float64(n) / d.Seconds()
+}
+
+// Writes the results of a batch run to w. The log format logs each file's
+// corrections and then writes a summary table comparing them to w.
+func writeBatch(w io.Writer, format string, results []*fileResult, prec precision) error {
+	switch format {
+	case "log":
+		for _, res := range results {
+			log.Printf("File: %s\n", res.File)
+			logResult(res, prec.text)
+		}
+		return writeSummary(w, results, prec.text)
+	case "table":
+		return writeSummary(w, results, prec.text)
+	case "json":
+		return writeJSON(w, results, prec.data)
+	default:
+		return fmt.Errorf("Unknown output format %s", format)
+	}
+}
+
+// Writes one row of offsets and gains per file as an aligned table with p
+// decimal places
+func writeSummary(w io.Writer, results []*fileResult, p int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "File\tOffset X\tGain X\tOffset Y\tGain Y\tOffset Z\tGain Z\tRMSE")
+
+	for _, res := range results {
+		fmt.Fprintf(tw, "%s", filepath.Base(res.File))
+		for _, c := range res.Corrections {
+			fmt.Fprintf(tw, "\t%.*f\t%.*f", p, c.Offset, p, c.Gain)
+		}
+		fmt.Fprintf(tw, "\t%.*f\n", p, res.Diagnostics.RMSE)
+	}
+
+	return tw.Flush()
+}
+
+// Writes the records as X,Y,Z rows with p decimal places, or as many as
+// needed if p is negative, to the CSV file at filePath, or to stdout if
+// filePath is "-"
+func writeRecordsFile(filePath string, records []*acc.Record, p int) error {
+	if filePath == "-" {
+		return writeRecords(os.Stdout, records, filePath, p)
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("Unable to create output file at path %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	if err := writeRecords(f, records, filePath, p); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeRecords(out io.Writer, records []*acc.Record, filePath string, p int) error {
+	if err := acc.WriteCSVRecords(out, records, p); err != nil {
+		return fmt.Errorf("Unable to write output file at path %s: %s", filePath, err.Error())
+	}
+
+	return nil
+}
+
+// Writes v as indented JSON to w, rounding every number with a fractional
+// part or exponent to p decimal places unless p is negative
+func writeJSON(w io.Writer, v interface{}, p int) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if p >= 0 {
+		data, err = roundJSON(data, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// Returns the compact JSON document with its non-integer numbers rounded to
+// p decimal places, keeping the order of object keys
+func roundJSON(data []byte, p int) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	// Open containers, with the number of tokens written into each. Every
+	// second token of an object is a value.
+	type container struct {
+		object bool
+		n      int
+	}
+	var stack []*container
+
+	var buf bytes.Buffer
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			buf.WriteRune(rune(d))
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if len(stack) > 0 {
+			c := stack[len(stack)-1]
+			switch {
+			case c.object && c.n%2 == 1:
+				buf.WriteByte(':')
+			case c.n > 0:
+				buf.WriteByte(',')
+			}
+			c.n++
+		}
+
+		switch t := tok.(type) {
+		case json.Delim:
+			buf.WriteRune(rune(t))
+			stack = append(stack, &container{object: t == '{'})
+		case json.Number:
+			s := t.String()
+			if strings.ContainsAny(s, ".eE") {
+				f, err := t.Float64()
+				if err != nil {
+					return nil, err
+				}
+				s = strconv.FormatFloat(f, 'f', p, 64)
+			}
+			buf.WriteString(s)
+		case string:
+			quoted, err := json.Marshal(t)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(quoted)
+		case bool:
+			buf.WriteString(strconv.FormatBool(t))
+		case nil:
+			buf.WriteString("null")
+		}
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Minimum time between two progress reports of the same stage
+const progressInterval = 500 * time.Millisecond
+
+// Reports calibration progress, either as a line rewritten in place on an
+// interactive terminal or as periodic log lines
+type progress struct {
+	w        io.Writer
+	terminal bool
+
+	stage string
+	last  time.Time
+}
+
+// Returns a progress reporter writing to stderr
+func newProgress() *progress {
+	terminal := false
+	if info, err := os.Stderr.Stat(); err == nil {
+		terminal = info.Mode()&os.ModeCharDevice != 0
+	}
+
+	return &progress{w: os.Stderr, terminal: terminal}
+}
+
+// Reports that done of total steps of stage are done. Reports within
+// progressInterval of the previous one are dropped unless the stage starts
+// or completes.
+func (p *progress) report(stage string, done, total int) {
+	now := time.Now()
+	if stage == p.stage && done < total && now.Sub(p.last) < progressInterval {
+		return
+	}
+	if stage != p.stage && p.stage != "" && p.terminal {
+		fmt.Fprintln(p.w)
+	}
+	p.stage = stage
+	p.last = now
+
+	label := "Computing epoch statistics"
+	if stage == "icp" {
+		label = "ICP iteration"
+	}
+	pct := 100 * float64(done) / float64(total)
+
+	if p.terminal {
+		fmt.Fprintf(p.w, "\r%s: %d of %d (%.0f%%)", label, done, total, pct)
+		return
+	}
+	log.Infof("%s: %d of %d (%.0f%%)", label, done, total, pct)
+}
+
+// Ends the line rewritten in place, if any
+func (p *progress) finish() {
+	if p.terminal && p.stage != "" {
+		fmt.Fprintln(p.w)
+	}
+	p.stage = ""
+}
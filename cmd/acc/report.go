@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tomcat-bit/acc"
+)
+
+// Machine-readable summary of a calibration, in the input unit
+type report struct {
+	File           string            `json:"file"`
+	Records        int               `json:"records"`
+	Epochs         int               `json:"epochs"`
+	RetainedEpochs int               `json:"retainedEpochs"`
+	SD             []*sdDistribution `json:"sd"`
+	Corrections    []*acc.Correction `json:"corrections"`
+	Diagnostics    *acc.Diagnostics  `json:"diagnostics"`
+	NaiveOffsets   [3]float64        `json:"naiveOffsets"`
+	Seed           int64             `json:"seed"`
+
+	// Whether the ICP weights of overlapping epochs were scaled down
+	OverlapWeighted bool `json:"overlapWeighted"`
+}
+
+// Returns the report of the result, computing the SD distribution of all
+// epochs with the settings in cfg
+func newReport(res *fileResult, cfg acc.Config, u acc.Unit) *report {
+	return &report{
+		File:           res.File,
+		Records:        len(res.records),
+		Epochs:         len(res.allEpochs),
+		RetainedEpochs: len(res.epochs),
+		SD:             sdDistributions(acc.SummarizeEpochs(res.allEpochs, cfg), u),
+		Corrections:    res.Corrections,
+		Diagnostics:    res.Diagnostics,
+		NaiveOffsets:   res.NaiveOffsets,
+		Seed:           cfg.Seed,
+
+		OverlapWeighted: cfg.OverlapWeighting,
+	}
+}
+
+// Writes v as indented JSON with p decimal places, or as many as needed if p
+// is negative, to the file at filePath
+func writeReportFile(filePath string, v interface{}, p int) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("Unable to create report file at path %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	if err := writeJSON(f, v, p); err != nil {
+		return fmt.Errorf("Unable to write report file at path %s: %s", filePath, err.Error())
+	}
+
+	return f.Close()
+}
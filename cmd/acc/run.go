@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tomcat-bit/acc"
+)
+
+// Fraction by which the measured sample rate may differ from -hz before
+// warning
+const maxRateDeviation = 0.1
+
+// Settings shared by every file calibrated in one invocation
+type pipeline struct {
+	// Input format, csv, json or bin
+	format   string
+	opts     acc.CSVOptions
+	jsonOpts acc.JSONOptions
+	binOpts  acc.BinaryOptions
+
+	// Thresholds are in m/s². RecordsPerSecond is the rate given by -hz,
+	// which is replaced by the measured rate of inputs with timestamps.
+	cfg acc.Config
+
+	// Number of records averaged into one before calibrating
+	decimate int
+
+	// Reports the progress of each calibration, nil without -progress
+	progress *progress
+
+	// Number of cross-validation folds, zero to skip cross-validation
+	folds int
+
+	// Width of the moving average applied before decimating, 1 for none
+	smooth int
+
+	// Subtract each file's per-axis mean before epoching, only for -stats
+	normalize bool
+
+	// Log the time each phase of a calibration took
+	showTiming bool
+
+	// Decimal places of the output
+	prec precision
+}
+
+// Outcome of calibrating one input file
+type fileResult struct {
+	File        string            `json:"file"`
+	Corrections []*acc.Correction `json:"corrections"`
+	Diagnostics *acc.Diagnostics  `json:"diagnostics"`
+
+	// Mean-based X, Y and Z offsets estimated without iterating
+	NaiveOffsets [3]float64 `json:"naiveOffsets"`
+
+	CrossValidation *crossValidation `json:"crossValidation,omitempty"`
+
+	// Raw records, retained and all epochs and the corrections, all in m/s²
+	records     []*acc.Record
+	epochs      []*acc.Epoch
+	allEpochs   []*acc.Epoch
+	corrections []*acc.Correction
+
+	// Whether the offsets are linear functions of the temperature
+	tempCompensated bool
+
+	timing timing
+}
+
+// Wall-clock time of the phases of one calibration. Parsing includes
+// smoothing, decimating and splitting the records into epochs.
+type timing struct {
+	parse, preProcess, icp time.Duration
+}
+
+// Returns true if the records carry timestamps
+func (p *pipeline) hasTime() bool {
+	switch p.format {
+	case "json":
+		return p.jsonOpts.TimeKey != ""
+	case "bin":
+		return false
+	}
+
+	return p.opts.ParseTime
+}
+
+// Returns true if the records carry sensor temperatures
+func (p *pipeline) hasTemp() bool {
+	switch p.format {
+	case "json":
+		return p.jsonOpts.TempKey != ""
+	case "bin":
+		return false
+	}
+
+	return p.opts.ParseTemp
+}
+
+// Reads the records of the file at filePath, or of stdin if it is "-"
+func (p *pipeline) loadRecords(filePath string) ([]*acc.Record, error) {
+	var records []*acc.Record
+	var err error
+	switch p.format {
+	case "json":
+		records, err = acc.ReadJSONFile(filePath, p.jsonOpts)
+	case "bin":
+		records, err = acc.ReadBinaryFile(filePath, p.binOpts)
+	default:
+		records, err = acc.ReadCSVFile(filePath, p.opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkUnits(records, p.opts.Units, p.cfg.Gravity)
+
+	return records, nil
+}
+
+// Returns the sample rate of the decimated records: the measured rate if
+// they carry timestamps and -hz / -decimate otherwise
+func (p *pipeline) sampleRate(records []*acc.Record) (float64, error) {
+	hz := p.cfg.RecordsPerSecond / float64(p.decimate)
+	if !p.hasTime() {
+		return hz, nil
+	}
+
+	rate, err := acc.EstimateSampleRate(records)
+	if err != nil {
+		return 0, err
+	}
+
+	if math.Abs(rate-hz)/hz > maxRateDeviation {
+		log.Warnf("Measured sample rate %.2f Hz deviates from %g Hz by more than %.0f%%", rate, hz, 100*maxRateDeviation)
+	}
+	log.Infof("Using measured sample rate of %.2f Hz", rate)
+
+	return rate, nil
+}
+
+// Smooths, decimates and, with -normalize, centres the records and returns
+// them with their configuration, using their measured sample rate if they
+// carry timestamps
+func (p *pipeline) config(records []*acc.Record) ([]*acc.Record, acc.Config, error) {
+	cfg := p.cfg
+	records = acc.Decimate(acc.Smooth(records, p.smooth), p.decimate)
+	if p.normalize {
+		records = acc.Center(records)
+	}
+
+	var err error
+	cfg.RecordsPerSecond, err = p.sampleRate(records)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	return records, cfg, nil
+}
+
+// Reads the files at filePaths, or stdin if the only path is "-", and splits
+// the records of each file into epochs of their own, so that no epoch spans
+// two files. Returns the raw records of all files in order and the
+// configuration used for the first file.
+func (p *pipeline) loadEpochs(filePaths []string) ([]*acc.Record, []*acc.Epoch, acc.Config, error) {
+	if err := p.checkLayout(filePaths); err != nil {
+		return nil, nil, p.cfg, err
+	}
+
+	var records []*acc.Record
+	var epochs []*acc.Epoch
+	var first acc.Config
+	for i, filePath := range filePaths {
+		raw, err := p.loadRecords(filePath)
+		if err != nil {
+			return nil, nil, p.cfg, err
+		}
+
+		decimated, cfg, err := p.config(raw)
+		if err != nil {
+			return nil, nil, cfg, err
+		}
+
+		if i == 0 {
+			first = cfg
+		} else if cfg.RecordsPerSecond != first.RecordsPerSecond {
+			log.Warnf("Sample rate of %s is %g Hz, unlike %g Hz of %s", filePath, cfg.RecordsPerSecond, first.RecordsPerSecond, filePaths[0])
+		}
+
+		fileEpochs, err := acc.GetEpochs(decimated, cfg)
+		if err != nil {
+			return nil, nil, cfg, err
+		}
+
+		if err := acc.ValidateEpochs(fileEpochs, cfg); err != nil {
+			return nil, nil, cfg, err
+		}
+
+		// Epochs are numbered across all files, as in the -stats output
+		for _, e := range fileEpochs {
+			e.Index += len(epochs)
+		}
+		records = append(records, raw...)
+		epochs = append(epochs, fileEpochs...)
+	}
+
+	return records, epochs, first, nil
+}
+
+// Returns an error unless the first data rows of all CSV files have the same
+// number of fields. JSON records are matched by key and binary records share
+// the layout given on the command line, so they need no check.
+func (p *pipeline) checkLayout(filePaths []string) error {
+	if p.format != "csv" || len(filePaths) < 2 {
+		return nil
+	}
+
+	width, err := csvWidth(filePaths[0], p.opts)
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range filePaths[1:] {
+		w, err := csvWidth(filePath, p.opts)
+		if err != nil {
+			return err
+		}
+		if w != width {
+			return fmt.Errorf("%s has %d columns but %s has %d", filePath, w, filePaths[0], width)
+		}
+	}
+
+	return nil
+}
+
+// Returns the number of fields of the first data row of the CSV file at
+// filePath
+func csvWidth(filePath string, opts acc.CSVOptions) (int, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to read input file at path %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	reader, err := acc.NewCSVRecordReader(f, opts)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to parse file at path %s: %s", filePath, err.Error())
+	}
+
+	if _, err := reader.Read(); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("Unable to parse file at path %s: %s", filePath, err.Error())
+	}
+
+	return reader.Width(), nil
+}
+
+// Runs the whole pipeline on the files at filePaths as one dataset, or on
+// stdin if the only path is "-"
+func (p *pipeline) calibrateFiles(ctx context.Context, filePaths []string) (*fileResult, error) {
+	start := time.Now()
+	records, epochs, cfg, err := p.loadEpochs(filePaths)
+	if err != nil {
+		return nil, err
+	}
+	parseDuration := time.Since(start)
+
+	c, err := acc.CalibrateEpochs(ctx, epochs, cfg)
+	if p.progress != nil {
+		p.progress.finish()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := acc.ConvertToMS2(1, p.opts.Units)
+	c.Diagnostics.RMSE /= f
+
+	naive := acc.NaiveOffsets(c.Epochs, cfg.Gravity)
+	for k := range naive {
+		naive[k] /= f
+	}
+
+	var cv *crossValidation
+	if p.folds > 0 {
+		// Progress is only reported for the full fit
+		fold := cfg
+		fold.Progress = nil
+		rmses, err := acc.CrossValidate(ctx, c.Epochs, fold, p.folds)
+		if err != nil {
+			return nil, err
+		}
+		cv = newCrossValidation(rmses, f)
+	}
+
+	return &fileResult{
+		File:        strings.Join(filePaths, ","),
+		Corrections: acc.ConvertCorrections(c.Corrections, p.opts.Units),
+		Diagnostics: c.Diagnostics,
+
+		NaiveOffsets:    naive,
+		CrossValidation: cv,
+
+		records:     records,
+		epochs:      c.Epochs,
+		allEpochs:   c.AllEpochs,
+		corrections: c.Corrections,
+
+		tempCompensated: cfg.TempCompensation,
+
+		timing: timing{
+			parse:      parseDuration,
+			preProcess: c.PreProcessDuration,
+			icp:        c.ICPDuration,
+		},
+	}, nil
+}
+
+// Applies the corrections in the JSON file at correctionsPath to the records
+// of filePath and writes the result to out. The corrections are in the
+// input unit.
+func (p *pipeline) applyFile(filePath, correctionsPath, out string) error {
+	corrections, err := acc.ReadCorrectionsFile(correctionsPath)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range corrections {
+		if c.TempSlope != 0 && !p.hasTemp() {
+			return fmt.Errorf("The corrections in %s are temperature-compensated but the input has no temperature column", correctionsPath)
+		}
+	}
+
+	records, err := p.loadRecords(filePath)
+	if err != nil {
+		return err
+	}
+
+	records = acc.ConvertRecords(records, p.opts.Units)
+	return writeRecordsFile(out, acc.ApplyCorrections(records, corrections), p.prec.data)
+}
+
+// Returns the input files in dir with the extension ext, plain or gzipped,
+// sorted by name
+func inputFiles(dir, ext string) ([]string, error) {
+	files := make([]string, 0)
+	for _, pattern := range []string{"*." + ext, "*." + ext + ".gz"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// Warns if the mean norm of the records, which are in m/s², suggests that
+// the input is in a different unit than u
+func checkUnits(records []*acc.Record, u acc.Unit, gravity float64) {
+	if len(records) == 0 {
+		return
+	}
+
+	sum := 0.0
+	n := 0
+	for _, r := range records {
+		norm := math.Sqrt(r.AccX*r.AccX + r.AccY*r.AccY + r.AccZ*r.AccZ)
+		// Records with a missing value have a NaN norm
+		if !math.IsNaN(norm) {
+			sum += norm
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	norm := sum / float64(n) / gravity
+
+	switch {
+	case u != acc.UnitG && norm > 0.05 && norm < 0.2:
+		log.Warnf("Mean norm is %.2f g assuming m/s² input. The input may be in g-units; see -units.", norm)
+	case u == acc.UnitG && norm > 5:
+		log.Warnf("Mean norm is %.2f g assuming g-unit input. The input may be in m/s²; see -units.", norm)
+	}
+}
+
+// Test RMSE of cross-validation folds, in the input unit
+type crossValidation struct {
+	Folds []float64 `json:"folds"`
+	Mean  float64   `json:"mean"`
+	SD    float64   `json:"sd"`
+}
+
+// Returns the cross-validation summary of the fold RMSEs, which are in m/s²
+// and divided by the unit factor f
+func newCrossValidation(rmses []float64, f float64) *crossValidation {
+	cv := &crossValidation{Folds: make([]float64, 0, len(rmses))}
+	for _, r := range rmses {
+		cv.Folds = append(cv.Folds, r/f)
+		cv.Mean += r / f
+	}
+	cv.Mean /= float64(len(rmses))
+
+	for _, r := range cv.Folds {
+		cv.SD += (r - cv.Mean) * (r - cv.Mean)
+	}
+	cv.SD = math.Sqrt(cv.SD / float64(len(rmses)))
+
+	return cv
+}
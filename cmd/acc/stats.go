@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tomcat-bit/acc"
+)
+
+// Writes the statistics of every epoch in the files as CSV to w and logs the
+// distribution of their SDs, without calibrating. With dumpPath set, the
+// stationary epochs are also selected and written to it.
+func (p *pipeline) runStats(ctx context.Context, w io.Writer, filePaths []string, dumpPath string) error {
+	_, epochs, cfg, err := p.loadEpochs(filePaths)
+	if err != nil {
+		return err
+	}
+
+	if dumpPath != "" {
+		retained, err := acc.PreProcessEpochs(ctx, epochs, cfg)
+		if err != nil {
+			return err
+		}
+		if err := p.writeEpochsFile(dumpPath, retained); err != nil {
+			return err
+		}
+	}
+
+	summaries := acc.SummarizeEpochs(epochs, cfg)
+	if err := writeEpochSummaries(w, summaries, p.opts.Units, p.prec.data); err != nil {
+		return err
+	}
+
+	logSDDistribution(summaries, p.opts.Units, p.prec.text)
+	return nil
+}
+
+// Writes one CSV row per epoch with its statistics in unit u
+func writeEpochSummaries(w io.Writer, summaries []*acc.EpochSummary, u acc.Unit, p int) error {
+	f := acc.ConvertToMS2(1, u)
+	format := func(v float64) string {
+		return strconv.FormatFloat(v/f, 'f', p, 64)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"index", "records", "meanX", "meanY", "meanZ", "sdX", "sdY", "sdZ", "norm", "meanNorm"})
+	for _, s := range summaries {
+		cw.Write([]string{
+			strconv.Itoa(s.Index),
+			strconv.Itoa(s.Records),
+			format(s.MeanX), format(s.MeanY), format(s.MeanZ),
+			format(s.SDX), format(s.SDY), format(s.SDZ),
+			format(s.Norm), format(s.MeanNorm),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Writes one CSV row per epoch with its index among epochs, mean vector, SDs
+// and norm in the input unit to the file at filePath. The epochs must be
+// stationary epochs returned by the pre-processing, which have already been
+// trimmed.
+func (p *pipeline) writeEpochsFile(filePath string, epochs []*acc.Epoch) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("Unable to create epoch file at path %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	cfg := p.cfg
+	cfg.Sigma = 0
+	summaries := acc.SummarizeEpochs(epochs, cfg)
+
+	u := acc.ConvertToMS2(1, p.opts.Units)
+	format := func(v float64) string {
+		return strconv.FormatFloat(v/u, 'f', p.prec.data, 64)
+	}
+
+	cw := csv.NewWriter(f)
+	cw.Write([]string{"index", "meanX", "meanY", "meanZ", "sdX", "sdY", "sdZ", "norm"})
+	for _, s := range summaries {
+		// s.Index is the position among the retained epochs
+		cw.Write([]string{
+			strconv.Itoa(epochs[s.Index].Index),
+			format(s.MeanX), format(s.MeanY), format(s.MeanZ),
+			format(s.SDX), format(s.SDY), format(s.SDZ),
+			format(s.Norm),
+		})
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("Unable to write epoch file at path %s: %s", filePath, err.Error())
+	}
+
+	return f.Close()
+}
+
+// Minimum, median and maximum SD of one axis across epochs
+type sdDistribution struct {
+	Axis   string  `json:"axis"`
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+}
+
+// Returns the SD distribution per axis in unit u, or nil if there are no
+// summaries
+func sdDistributions(summaries []*acc.EpochSummary, u acc.Unit) []*sdDistribution {
+	if len(summaries) == 0 {
+		return nil
+	}
+
+	f := acc.ConvertToMS2(1, u)
+	axes := []struct {
+		name string
+		sd   func(*acc.EpochSummary) float64
+	}{
+		{"X", func(s *acc.EpochSummary) float64 { return s.SDX }},
+		{"Y", func(s *acc.EpochSummary) float64 { return s.SDY }},
+		{"Z", func(s *acc.EpochSummary) float64 { return s.SDZ }},
+	}
+
+	distributions := make([]*sdDistribution, 0, len(axes))
+	for _, axis := range axes {
+		sds := make([]float64, 0, len(summaries))
+		for _, s := range summaries {
+			sds = append(sds, axis.sd(s)/f)
+		}
+		sort.Float64s(sds)
+
+		distributions = append(distributions, &sdDistribution{
+			Axis:   axis.name,
+			Min:    sds[0],
+			Median: median(sds),
+			Max:    sds[len(sds)-1],
+		})
+	}
+
+	return distributions
+}
+
+// Logs the minimum, median and maximum SD per axis in unit u with p decimal
+// places
+func logSDDistribution(summaries []*acc.EpochSummary, u acc.Unit, p int) {
+	distributions := sdDistributions(summaries, u)
+	if distributions == nil {
+		log.Warnln("No epochs to summarize")
+		return
+	}
+
+	for _, d := range distributions {
+		log.Printf("Axis: %s\tSD min: %.*f\tmedian: %.*f\tmax: %.*f\n", d.Axis, p, d.Min, p, d.Median, p, d.Max)
+	}
+}
+
+// Returns the median of the sorted, non-empty values
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+
+	return sorted[n/2]
+}
+
+// Writes the overlapping Allan deviation of each axis of the records in the
+// file as CSV to w, in the input unit
+func (p *pipeline) runAllan(w io.Writer, filePath string) error {
+	records, err := p.loadRecords(filePath)
+	if err != nil {
+		return err
+	}
+
+	records, cfg, err := p.config(records)
+	if err != nil {
+		return err
+	}
+
+	f := acc.ConvertToMS2(1, p.opts.Units)
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', p.prec.data, 64)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"tau", "adevX", "adevY", "adevZ"})
+	for _, pt := range acc.AllanDeviation(records, cfg.RecordsPerSecond) {
+		cw.Write([]string{
+			format(pt.Tau),
+			format(pt.Deviation[0] / f), format(pt.Deviation[1] / f), format(pt.Deviation[2] / f),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
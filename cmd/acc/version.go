@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// Set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse --short HEAD)"
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+func printVersion(w io.Writer) {
+	fmt.Fprintf(w, "acc %s (commit %s, %s %s/%s)\n", version, commit, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
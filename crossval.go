@@ -0,0 +1,54 @@
+package acc
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Splits the non-empty epochs into k interleaved folds, fits corrections
+// with ICP on all folds but one and returns the RMSE of the residuals of the
+// held-out fold, in m/s², for each fold in turn. The fits log their outcome
+// at debug level only. Returns the context's error if it is cancelled.
+func CrossValidate(ctx context.Context, epochs []*Epoch, cfg Config, k int) ([]float64, error) {
+	nonEmpty := make([]*Epoch, 0, len(epochs))
+	for _, e := range epochs {
+		if len(e.Records) > 0 {
+			nonEmpty = append(nonEmpty, e)
+		}
+	}
+
+	if k < 2 {
+		return nil, errorf(ErrInvalidConfig, "Cross-validation needs at least 2 folds, got %d", k)
+	}
+	if len(nonEmpty) < k {
+		return nil, errorf(ErrNoEpochs, "Cannot split %d epochs into %d folds", len(nonEmpty), k)
+	}
+
+	rmses := make([]float64, 0, k)
+	for fold := 0; fold < k; fold++ {
+		train := make([]*Epoch, 0, len(nonEmpty))
+		test := make([]*Epoch, 0, len(nonEmpty)/k+1)
+		for i, e := range nonEmpty {
+			if i%k == fold {
+				test = append(test, e)
+			} else {
+				train = append(train, e)
+			}
+		}
+
+		corrections, _, err := icp(ctx, train, cfg, true)
+		if err != nil {
+			return nil, fmt.Errorf("Fold %d: %w", fold, err)
+		}
+
+		sum := 0.0
+		residuals := Residuals(test, corrections, cfg.Gravity)
+		for _, r := range residuals {
+			sum += r * r
+		}
+		rmses = append(rmses, math.Sqrt(sum/float64(len(residuals))))
+	}
+
+	return rmses, nil
+}
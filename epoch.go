@@ -0,0 +1,650 @@
+package acc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A window of consecutive records
+type Epoch struct {
+	Records []*Record
+
+	// Position of the epoch among those returned by GetEpochs, which
+	// PreProcessEpochs keeps so that retained epochs can be matched to the
+	// others. -1 for epochs merged by ClusterEpochs.
+	Index int
+}
+
+// Returns the number of values of each axis, excluding missing ones
+func (e *Epoch) Counts() (int, int, int) {
+	var counts [3]int
+	for _, r := range e.Records {
+		for k, v := range [3]float64{r.AccX, r.AccY, r.AccZ} {
+			if !math.IsNaN(v) {
+				counts[k]++
+			}
+		}
+	}
+
+	return counts[0], counts[1], counts[2]
+}
+
+// Returns the norm of the epoch's mean vector. Callers must not pass an
+// empty epoch.
+func (e *Epoch) EuclideanNorm() float64 {
+	meanX, meanY, meanZ := e.Mean()
+	return math.Sqrt(math.Pow(meanX, 2) + math.Pow(meanY, 2) + math.Pow(meanZ, 2))
+}
+
+// Returns the mean of the norms of the epoch's records, skipping records
+// with a missing value. Unlike EuclideanNorm, opposing transients within the
+// epoch do not cancel out.
+func (e *Epoch) meanNorm() float64 {
+	sum := 0.0
+	n := 0
+	for _, r := range e.Records {
+		if r.incomplete() {
+			continue
+		}
+		sum += math.Sqrt(r.AccX*r.AccX + r.AccY*r.AccY + r.AccZ*r.AccZ)
+		n++
+	}
+
+	return sum / float64(n)
+}
+
+// Returns the number of runs of at least n identical consecutive records in
+// the epoch. Records with a missing value never match.
+func (e *Epoch) stuckRuns(n int) int {
+	runs := 0
+	length := 1
+	for i := 1; i <= len(e.Records); i++ {
+		if i < len(e.Records) && e.Records[i].sameReading(e.Records[i-1]) {
+			length++
+			continue
+		}
+
+		if length >= n {
+			runs++
+		}
+		length = 1
+	}
+
+	return runs
+}
+
+// Returns for each of the non-empty epochs the inverse of the mean number of
+// the epochs holding each of its records. Overlapping epochs share their
+// records, so an epoch whose records are each shared with one neighbour
+// gets 0.5 and an epoch sharing none gets 1.
+func overlapWeights(epochs []*Epoch) []float64 {
+	coverage := make(map[*Record]int)
+	for _, e := range epochs {
+		for _, r := range e.Records {
+			coverage[r]++
+		}
+	}
+
+	weights := make([]float64, len(epochs))
+	for i, e := range epochs {
+		sum := 0
+		for _, r := range e.Records {
+			sum += coverage[r]
+		}
+		weights[i] = float64(len(e.Records)) / float64(sum)
+	}
+
+	return weights
+}
+
+// Returns the mean sensor temperature of the epoch's records
+func (e *Epoch) meanTemp() float64 {
+	sum := 0.0
+	for _, r := range e.Records {
+		sum += r.Temp
+	}
+
+	return sum / float64(len(e.Records))
+}
+
+// Returns the epochs whose SD on each axis is below that axis' threshold in
+// cfg.Thresholds; an SD equal to its threshold fails. Epochs of fewer than
+// two records, whose SD says nothing about motion, are skipped. If
+// cfg.Sigma is set, outlying records are trimmed from each epoch before the
+// test and the trimmed epoch is returned in its place.
+// Epochs holding runs of cfg.StuckRun identical records are warned about,
+// and discarded if cfg.DropStuck is set. Returns the context's error if it
+// is cancelled.
+func PreProcessEpochs(ctx context.Context, epochs []*Epoch, cfg Config) ([]*Epoch, error) {
+	if len(epochs) == 0 {
+		return nil, errorf(ErrNoEpochs, "No epochs to pre-process")
+	}
+
+	thresholds := cfg.Thresholds
+	stats, err := computeEpochStats(ctx, epochs, cfg)
+	if err != nil {
+		return nil, err
+	}
+	processed := make([]*Epoch, 0)
+	stuckRuns, stuckEpochs := 0, 0
+
+	for i, e := range epochs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// The mean of an empty epoch is NaN and never passes the threshold
+		if len(e.Records) == 0 {
+			log.Warnf("Skipping empty epoch %d", e.Index)
+			continue
+		}
+
+		if len(e.Records) < 2 {
+			log.Debugf("Skipping epoch %d of a single record", e.Index)
+			continue
+		}
+
+		if cfg.StuckRun > 0 {
+			if runs := e.stuckRuns(cfg.StuckRun); runs > 0 {
+				log.Debugf("Epoch %d holds %d runs of at least %d identical records", e.Index, runs, cfg.StuckRun)
+				stuckRuns += runs
+				stuckEpochs++
+				if cfg.DropStuck {
+					continue
+				}
+			}
+		}
+
+		s := stats[i]
+		if s.trimmed != nil {
+			log.Debugf("Trimmed %d of %d records from epoch %d", len(e.Records)-len(s.trimmed.Records), len(e.Records), e.Index)
+			e = s.trimmed
+		}
+
+		sds := s.spread(cfg.Robust)
+		if belowThresholds(sds, thresholds) {
+			processed = append(processed, e)
+			continue
+		}
+
+		if log.IsLevelEnabled(log.DebugLevel) {
+			failed := make([]string, 0, 3)
+			for k, sd := range sds {
+				if sd >= thresholds[k] {
+					failed = append(failed, fmt.Sprintf("%c (%f >= %f)", axes[k], sd, thresholds[k]))
+				}
+			}
+			log.Debugf("Rejected epoch %d with %ss X: %f Y: %f Z: %f, exceeded on %s",
+				e.Index, spreadName(cfg.Robust), sds[0], sds[1], sds[2], strings.Join(failed, ", "))
+		}
+	}
+
+	if stuckEpochs > 0 {
+		action := "kept"
+		if cfg.DropStuck {
+			action = "discarded"
+		}
+		log.Warnf("Found %d runs of at least %d identical consecutive records, suggesting a stuck sensor, in %d epochs, which were %s",
+			stuckRuns, cfg.StuckRun, stuckEpochs, action)
+	}
+
+	retained := len(processed)
+	log.Infof("Retained %d of %d epochs (%.1f%%), discarded %d",
+		retained, len(epochs), 100*float64(retained)/float64(len(epochs)), len(epochs)-retained)
+
+	if retained == 0 {
+		log.Warnf("No epochs have a per-axis %s below the thresholds %v m/s². The thresholds may be too tight.", spreadName(cfg.Robust), thresholds)
+		logThresholdHint(epochs, stats, cfg)
+	}
+
+	return processed, nil
+}
+
+// Reports whether the dispersion of every axis is below its threshold
+func belowThresholds(sds, thresholds [3]float64) bool {
+	return sds[0] < thresholds[0] && sds[1] < thresholds[1] && sds[2] < thresholds[2]
+}
+
+// Returns the name of the dispersion tested against the thresholds
+func spreadName(robust bool) string {
+	if robust {
+		return "MAD"
+	}
+
+	return "SD"
+}
+
+// Logs the smallest SD, or MAD if cfg.Robust is set, of each axis across
+// the epochs of at least two records and a single threshold that would
+// retain at least the stillest of them, which is the smallest largest-axis
+// value of any epoch plus a 10% margin. The values are given in m/s² and in
+// g-units of cfg.Gravity, and the threshold also as a percentage of it, as
+// the unit of the input is not known here.
+func logThresholdHint(epochs []*Epoch, stats []epochStats, cfg Config) {
+	minSDs := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}
+	suggested := math.Inf(1)
+	for i, e := range epochs {
+		if len(e.Records) < 2 {
+			continue
+		}
+
+		sds := stats[i].spread(cfg.Robust)
+		for k, sd := range sds {
+			minSDs[k] = math.Min(minSDs[k], sd)
+		}
+		suggested = math.Min(suggested, math.Max(sds[0], math.Max(sds[1], sds[2])))
+	}
+
+	if math.IsInf(suggested, 1) {
+		return
+	}
+
+	g := cfg.Gravity
+	suggested *= 1.1
+	log.Warnf("Smallest epoch %ss are X: %f Y: %f Z: %f m/s², or X: %f Y: %f Z: %f g. A threshold of %f m/s², %f g or %.3f%% of gravity would retain at least one epoch.",
+		spreadName(cfg.Robust), minSDs[0], minSDs[1], minSDs[2], minSDs[0]/g, minSDs[1]/g, minSDs[2]/g,
+		suggested, suggested/g, 100*suggested/g)
+}
+
+// Checks that every epoch but the last holds cfg.EpochSize() - cfg.Settle
+// records, logging a warning for each one that does not. If cfg.MaxGap is
+// set, epochs followed by a time gap may also be shorter. If cfg.Strict is
+// set the first such epoch is returned as an error instead.
+func ValidateEpochs(epochs []*Epoch, cfg Config) error {
+	size := cfg.EpochSize() - cfg.Settle
+
+	invalid := 0
+	for i, e := range epochs {
+		if i == len(epochs)-1 || len(e.Records) == size {
+			continue
+		}
+		if cfg.MaxGap > 0 && len(e.Records) > 0 && len(epochs[i+1].Records) > 0 &&
+			epochs[i+1].Records[0].Time-e.Records[len(e.Records)-1].Time > cfg.MaxGap {
+			continue
+		}
+
+		if cfg.Strict {
+			return errorf(ErrEpochSize, "Epoch %d holds %d records, expected %d", e.Index, len(e.Records), size)
+		}
+
+		log.Warnf("Epoch %d holds %d records, expected %d", e.Index, len(e.Records), size)
+		invalid++
+	}
+
+	if invalid > 0 {
+		log.Warnf("%d of %d epochs have an unexpected record count", invalid, len(epochs))
+	}
+
+	return nil
+}
+
+// Per-axis statistics of an epoch
+type epochStats struct {
+	meanX, meanY, meanZ float64
+	sdX, sdY, sdZ       float64
+
+	// Per-axis median absolute deviation, only computed with Config.Robust
+	mad [3]float64
+
+	// The epoch without its outlying records, which the statistics
+	// describe. Nil if no records were trimmed.
+	trimmed *Epoch
+}
+
+// Returns the per-axis dispersion tested against the thresholds: the MAD if
+// robust is set, the SD otherwise
+func (s epochStats) spread(robust bool) [3]float64 {
+	if robust {
+		return s.mad
+	}
+
+	return [3]float64{s.sdX, s.sdY, s.sdZ}
+}
+
+// Computes the statistics of every non-empty epoch using up to cfg.Workers
+// goroutines, after trimming outliers if cfg.Sigma is set. The result is
+// indexed like epochs. Returns the context's error if it is cancelled
+// before all epochs are processed.
+func computeEpochStats(ctx context.Context, epochs []*Epoch, cfg Config) ([]epochStats, error) {
+	stats := make([]epochStats, len(epochs))
+
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(epochs) {
+		workers = len(epochs)
+	}
+
+	// Progress is reported as the workers complete epochs. The lock keeps
+	// the calls from overlapping and the count from going backwards.
+	var mu sync.Mutex
+	completed := 0
+	complete := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		completed++
+		cfg.progress("epochs", completed, len(epochs))
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				e := epochs[i]
+				if len(e.Records) == 0 {
+					complete()
+					continue
+				}
+
+				mean, variance := e.stats(cfg.SampleSD)
+				var trimmed *Epoch
+				if cfg.Sigma > 0 {
+					trimmed = e.trim(mean, variance, cfg.Sigma)
+					if trimmed != nil {
+						mean, variance = trimmed.stats(cfg.SampleSD)
+					}
+				}
+
+				stats[i] = epochStats{
+					meanX:   mean[0],
+					meanY:   mean[1],
+					meanZ:   mean[2],
+					sdX:     sqrtVariance(variance[0]),
+					sdY:     sqrtVariance(variance[1]),
+					sdZ:     sqrtVariance(variance[2]),
+					trimmed: trimmed,
+				}
+				if cfg.Robust {
+					tested := e
+					if trimmed != nil {
+						tested = trimmed
+					}
+					x, y, z := tested.MAD()
+					stats[i].mad = [3]float64{x, y, z}
+				}
+				complete()
+			}
+		}()
+	}
+
+feed:
+	for i := range epochs {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Splits records into epochs of cfg.EpochSeconds each, given a sample rate
+// of cfg.RecordsPerSecond. Consecutive epochs start cfg.Stride records apart
+// and overlap when the stride is less than the epoch size. The last epoch,
+// which reaches the end of records, may be shorter unless cfg.DropPartial
+// is set. If cfg.MaxGap is set, the records are first split at gaps between
+// their timestamps and each part is split into epochs on its own, so no epoch
+// spans a gap and the last epoch of each part may be shorter. The first
+// cfg.Settle records of each epoch are left out, and a trailing epoch left
+// with no records is dropped. Returns an error if an
+// epoch would hold no records, since the window would then never advance.
+func GetEpochs(records []*Record, cfg Config) ([]*Epoch, error) {
+	if len(records) == 0 {
+		return nil, errorf(ErrNoData, "No records to split into epochs. The input may be empty or unparseable")
+	}
+
+	size := cfg.EpochSize()
+	// An empty window never shrinks records
+	if size < 1 {
+		return nil, errorf(ErrInvalidConfig, "An epoch of %g s at %g Hz holds no records", cfg.EpochSeconds, cfg.RecordsPerSecond)
+	}
+	if cfg.Settle < 0 || cfg.Settle >= size {
+		return nil, errorf(ErrInvalidConfig, "Settling must discard between 0 and %d of the %d records of each epoch", size-1, size)
+	}
+	stride := cfg.Stride
+	if stride <= 0 {
+		stride = size
+	}
+
+	segments := [][]*Record{records}
+	if cfg.MaxGap > 0 {
+		segments = splitAtGaps(records, cfg.MaxGap)
+		if len(segments) > 1 {
+			log.Infof("Split the records at %d time gaps of more than %g s", len(segments)-1, cfg.MaxGap)
+		}
+	}
+
+	epochs := make([]*Epoch, 0)
+	for _, segment := range segments {
+		epochs = appendWindows(epochs, segment, size, stride, cfg)
+	}
+
+	return epochs, nil
+}
+
+// Returns the records split before each record whose timestamp is more than
+// maxGap seconds after that of its predecessor
+func splitAtGaps(records []*Record, maxGap float64) [][]*Record {
+	segments := make([][]*Record, 0, 1)
+	start := 0
+	for i := 1; i < len(records); i++ {
+		if records[i].Time-records[i-1].Time > maxGap {
+			segments = append(segments, records[start:i])
+			start = i
+		}
+	}
+
+	return append(segments, records[start:])
+}
+
+// Appends the epochs of size records starting stride records apart to
+// epochs, as described by GetEpochs
+func appendWindows(epochs []*Epoch, records []*Record, size, stride int, cfg Config) []*Epoch {
+	for {
+		if len(records) == 0 {
+			break
+		}
+
+		n := size
+		if len(records) < n {
+			if cfg.DropPartial {
+				break
+			}
+			n = len(records)
+		}
+
+		if cfg.Settle < n {
+			epochs = append(epochs, &Epoch{
+				Records: records[cfg.Settle:n],
+				Index:   len(epochs),
+			})
+		}
+
+		// Later windows would only hold records already in this one
+		if n == len(records) || stride > len(records) {
+			break
+		}
+		records = records[stride:]
+	}
+
+	return epochs
+}
+
+// Running mean and variance of one axis using Welford's algorithm
+type welford struct {
+	n    int
+	mean float64
+
+	// Sum of squared deviations from the running mean
+	m2 float64
+}
+
+// Adds x unless it is NaN, marking a missing value
+func (w *welford) add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+
+	w.n++
+	d := x - w.mean
+	w.mean += d / float64(w.n)
+	w.m2 += d * (x - w.mean)
+}
+
+// Returns the population variance, or the sample variance if sample is set
+func (w *welford) variance(sample bool) float64 {
+	l := float64(w.n)
+	if sample {
+		l--
+	}
+
+	// The sample variance of a single record is undefined
+	if l <= 0 {
+		return math.NaN()
+	}
+
+	return w.m2 / l
+}
+
+// Returns the per-axis mean and variance in a single pass, using the sample
+// variance if sample is set. Callers must not pass an empty epoch.
+func (e *Epoch) stats(sample bool) ([3]float64, [3]float64) {
+	var x, y, z welford
+	for _, r := range e.Records {
+		x.add(r.AccX)
+		y.add(r.AccY)
+		z.add(r.AccZ)
+	}
+
+	mean := [3]float64{x.mean, y.mean, z.mean}
+	variance := [3]float64{x.variance(sample), y.variance(sample), z.variance(sample)}
+	for k, w := range []welford{x, y, z} {
+		if w.n == 0 {
+			mean[k], variance[k] = math.NaN(), math.NaN()
+		}
+	}
+
+	return mean, variance
+}
+
+// Returns the per-axis mean. Missing values are excluded, so each axis is
+// averaged over its own count of values, and the mean of an axis without
+// any values is NaN. Callers must not pass an empty epoch.
+func (e *Epoch) Mean() (float64, float64, float64) {
+	mean, _ := e.stats(false)
+	return mean[0], mean[1], mean[2]
+}
+
+// Returns the per-axis population SD, or the sample SD if sample is set.
+// Missing values are excluded as in Mean. The sample SD of a single value is
+// NaN. Callers must not pass an empty epoch.
+func (e *Epoch) StandardDeviation(sample bool) (float64, float64, float64) {
+	_, variance := e.stats(sample)
+	return sqrtVariance(variance[0]), sqrtVariance(variance[1]), sqrtVariance(variance[2])
+}
+
+// Returns the per-axis median. Callers must not pass an empty epoch.
+func (e *Epoch) Median() (float64, float64, float64) {
+	medians, _ := e.medianAbsoluteDeviation()
+	return medians[0], medians[1], medians[2]
+}
+
+// Returns the per-axis median absolute deviation from the median, unscaled.
+// For normally distributed noise it is about 0.6745 times the SD, so
+// thresholds for it must be lowered accordingly. Callers must not pass an
+// empty epoch.
+func (e *Epoch) MAD() (float64, float64, float64) {
+	_, mads := e.medianAbsoluteDeviation()
+	return mads[0], mads[1], mads[2]
+}
+
+// Returns the per-axis median and median absolute deviation, excluding
+// missing values. Both are NaN for an axis without any values.
+func (e *Epoch) medianAbsoluteDeviation() ([3]float64, [3]float64) {
+	var medians, mads [3]float64
+	for k := range medians {
+		values := make([]float64, 0, len(e.Records))
+		for _, r := range e.Records {
+			if v := [3]float64{r.AccX, r.AccY, r.AccZ}[k]; !math.IsNaN(v) {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			medians[k], mads[k] = math.NaN(), math.NaN()
+			continue
+		}
+		medians[k] = median(values)
+
+		for i, v := range values {
+			values[i] = math.Abs(v - medians[k])
+		}
+		mads[k] = median(values)
+	}
+
+	return medians, mads
+}
+
+// Returns the median of the values, which it sorts
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// Returns the epoch without the records lying more than sigma SDs from the
+// mean on any axis, given the epoch's per-axis mean and variance. Returns
+// nil if no records would be trimmed or fewer than two would remain.
+func (e *Epoch) trim(mean, variance [3]float64, sigma float64) *Epoch {
+	var limits [3]float64
+	for k := range limits {
+		limits[k] = sigma * sqrtVariance(variance[k])
+	}
+
+	// Written as negated comparisons so that missing values, which are
+	// NaN, are never outliers
+	kept := make([]*Record, 0, len(e.Records))
+	for _, r := range e.Records {
+		if !(math.Abs(r.AccX-mean[0]) > limits[0]) &&
+			!(math.Abs(r.AccY-mean[1]) > limits[1]) &&
+			!(math.Abs(r.AccZ-mean[2]) > limits[2]) {
+			kept = append(kept, r)
+		}
+	}
+
+	if len(kept) == len(e.Records) || len(kept) < 2 {
+		return nil
+	}
+
+	return &Epoch{Records: kept, Index: e.Index}
+}
+
+// Returns the SD of the variance v, treating rounding below zero as zero
+// rather than returning NaN
+func sqrtVariance(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+
+	return math.Sqrt(v)
+}
@@ -0,0 +1,725 @@
+package acc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Only errors are logged, so that the benchmark results stay readable
+func TestMain(m *testing.M) {
+	log.SetLevel(log.ErrorLevel)
+	os.Exit(m.Run())
+}
+
+// Returns records holding the given X, Y and Z values
+func recordsOf(values ...[3]float64) []*Record {
+	records := make([]*Record, 0, len(values))
+	for _, v := range values {
+		records = append(records, &Record{AccX: v[0], AccY: v[1], AccZ: v[2]})
+	}
+
+	return records
+}
+
+// Returns n records scattered around mean with normally distributed noise
+// of SD noise on each axis
+func noisyRecords(rng *rand.Rand, n int, mean [3]float64, noise float64) []*Record {
+	records := make([]*Record, 0, n)
+	for i := 0; i < n; i++ {
+		records = append(records, &Record{
+			AccX: mean[0] + noise*rng.NormFloat64(),
+			AccY: mean[1] + noise*rng.NormFloat64(),
+			AccZ: mean[2] + noise*rng.NormFloat64(),
+		})
+	}
+
+	return records
+}
+
+// Returns a configuration with epochs of size records at 1 Hz and the
+// given threshold on every axis
+func testConfig(size int, threshold float64) Config {
+	cfg := DefaultConfig()
+	cfg.RecordsPerSecond = 1
+	cfg.EpochSeconds = float64(size)
+	cfg.Thresholds = [3]float64{threshold, threshold, threshold}
+	return cfg
+}
+
+func closeTo(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestMean(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []*Record
+		want    [3]float64
+	}{
+		{"single record", recordsOf([3]float64{1, -2, 9.81}), [3]float64{1, -2, 9.81}},
+		{"constant", recordsOf([3]float64{0.1, 0.2, 9.7}, [3]float64{0.1, 0.2, 9.7}, [3]float64{0.1, 0.2, 9.7}), [3]float64{0.1, 0.2, 9.7}},
+		{"known values", recordsOf([3]float64{1, 2, 3}, [3]float64{3, 4, 5}), [3]float64{2, 3, 4}},
+		{"opposite signs", recordsOf([3]float64{-1, 5, 0}, [3]float64{1, -5, 0}), [3]float64{0, 0, 0}},
+		{"missing value", recordsOf([3]float64{1, 2, 3}, [3]float64{math.NaN(), 4, 5}), [3]float64{1, 3, 4}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Epoch{Records: test.records}
+			x, y, z := e.Mean()
+			for k, got := range [3]float64{x, y, z} {
+				if !closeTo(got, test.want[k], 1e-12) {
+					t.Errorf("mean %c = %g, want %g", axes[k], got, test.want[k])
+				}
+			}
+		})
+	}
+}
+
+func TestStandardDeviation(t *testing.T) {
+	// The population SD of 2, 4, 4, 4, 5, 5, 7, 9 is 2
+	var known [][3]float64
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		known = append(known, [3]float64{v, -v, 10 * v})
+	}
+
+	tests := []struct {
+		name    string
+		records []*Record
+		sample  bool
+		want    [3]float64
+	}{
+		{"single record", recordsOf([3]float64{1, 2, 3}), false, [3]float64{0, 0, 0}},
+		{"single record sample", recordsOf([3]float64{1, 2, 3}), true, [3]float64{math.NaN(), math.NaN(), math.NaN()}},
+		{"constant", recordsOf([3]float64{0.1, 0.2, 9.7}, [3]float64{0.1, 0.2, 9.7}), false, [3]float64{0, 0, 0}},
+		{"known values", recordsOf(known...), false, [3]float64{2, 2, 20}},
+		{"known values sample", recordsOf(known...), true, [3]float64{math.Sqrt(32.0 / 7), math.Sqrt(32.0 / 7), 10 * math.Sqrt(32.0/7)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Epoch{Records: test.records}
+			x, y, z := e.StandardDeviation(test.sample)
+			for k, got := range [3]float64{x, y, z} {
+				if !closeTo(got, test.want[k], 1e-12) && !(math.IsNaN(got) && math.IsNaN(test.want[k])) {
+					t.Errorf("SD %c = %g, want %g", axes[k], got, test.want[k])
+				}
+			}
+		})
+	}
+}
+
+func TestEuclideanNorm(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []*Record
+		want    float64
+	}{
+		{"single record", recordsOf([3]float64{3, 4, 0}), 5},
+		{"gravity on Z", recordsOf([3]float64{0, 0, -9.81}, [3]float64{0, 0, -9.81}), 9.81},
+		{"norm of the mean", recordsOf([3]float64{3, 4, 0}, [3]float64{-3, 4, 0}), 4},
+		{"zero", recordsOf([3]float64{0, 0, 0}), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Epoch{Records: test.records}
+			if got := e.EuclideanNorm(); !closeTo(got, test.want, 1e-12) {
+				t.Errorf("norm = %g, want %g", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetEpochs(t *testing.T) {
+	tests := []struct {
+		name    string
+		records int
+		size    int
+		stride  int
+		settle  int
+		partial bool
+		want    []int
+	}{
+		{"exact multiple", 9, 3, 0, 0, false, []int{3, 3, 3}},
+		{"partial epoch kept", 10, 3, 0, 0, false, []int{3, 3, 3, 1}},
+		{"partial epoch dropped", 10, 3, 0, 0, true, []int{3, 3, 3}},
+		{"fewer records than an epoch", 2, 3, 0, 0, false, []int{2}},
+		{"single record epochs", 3, 1, 0, 0, false, []int{1, 1, 1}},
+		{"overlapping", 6, 4, 2, 0, false, []int{4, 4}},
+		{"settling", 9, 3, 0, 1, false, []int{2, 2, 2}},
+		{"settling drops an emptied partial epoch", 7, 3, 0, 1, false, []int{2, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(test.size, 1)
+			cfg.Stride = test.stride
+			cfg.Settle = test.settle
+			cfg.DropPartial = test.partial
+
+			values := make([][3]float64, test.records)
+			for i := range values {
+				values[i] = [3]float64{float64(i), 0, 0}
+			}
+
+			epochs, err := GetEpochs(recordsOf(values...), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(epochs) != len(test.want) {
+				t.Fatalf("got %d epochs, want %d", len(epochs), len(test.want))
+			}
+			stride := test.stride
+			if stride == 0 {
+				stride = test.size
+			}
+			for i, e := range epochs {
+				if len(e.Records) != test.want[i] {
+					t.Errorf("epoch %d holds %d records, want %d", i, len(e.Records), test.want[i])
+				}
+				if e.Index != i {
+					t.Errorf("epoch %d has index %d", i, e.Index)
+				}
+				if first := e.Records[0].AccX; first != float64(i*stride+test.settle) {
+					t.Errorf("epoch %d starts at record %g, want %d", i, first, i*stride+test.settle)
+				}
+			}
+		})
+	}
+}
+
+func TestPreProcessEpochs(t *testing.T) {
+	// The X values 0 and 2 have a population SD of exactly 1
+	spread := func() *Epoch {
+		return &Epoch{Records: recordsOf([3]float64{0, 0, 9.81}, [3]float64{2, 0, 9.81})}
+	}
+	constant := func() *Epoch {
+		return &Epoch{Records: recordsOf([3]float64{0, 0, 9.81}, [3]float64{0, 0, 9.81})}
+	}
+
+	tests := []struct {
+		name      string
+		epochs    []*Epoch
+		threshold float64
+		want      int
+	}{
+		{"SD below the threshold", []*Epoch{spread()}, 1.000001, 1},
+		{"SD equal to the threshold", []*Epoch{spread()}, 1, 0},
+		{"SD above the threshold", []*Epoch{spread()}, 0.999999, 0},
+		{"constant epoch", []*Epoch{constant()}, 1e-9, 1},
+		{"single record epoch skipped", []*Epoch{{Records: recordsOf([3]float64{0, 0, 9.81})}}, 1e-9, 0},
+		{"empty epoch skipped", []*Epoch{{}, constant(), {}}, 1e-9, 1},
+		{"only empty epochs", []*Epoch{{}, {}}, 1, 0},
+		{"mixed", []*Epoch{spread(), constant(), spread(), constant()}, 0.5, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retained, err := PreProcessEpochs(context.Background(), test.epochs, testConfig(2, test.threshold))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(retained) != test.want {
+				t.Errorf("retained %d epochs, want %d", len(retained), test.want)
+			}
+		})
+	}
+
+	t.Run("no epochs", func(t *testing.T) {
+		_, err := PreProcessEpochs(context.Background(), nil, testConfig(2, 1))
+		if !errors.Is(err, ErrNoEpochs) {
+			t.Errorf("got error %v, want ErrNoEpochs", err)
+		}
+	})
+}
+
+// Returns 2000 epochs of 300 records, as in a recording of about 17 hours at
+// 10 Hz
+func benchmarkEpochs() []*Epoch {
+	rng := rand.New(rand.NewSource(1))
+	epochs := make([]*Epoch, 0, 2000)
+	for i := 0; i < cap(epochs); i++ {
+		epochs = append(epochs, &Epoch{Records: noisyRecords(rng, 300, [3]float64{0.1, -0.2, 9.81}, 0.01)})
+	}
+
+	return epochs
+}
+
+func BenchmarkMean(b *testing.B) {
+	e := &Epoch{Records: noisyRecords(rand.New(rand.NewSource(1)), 300, [3]float64{0.1, -0.2, 9.81}, 0.01)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Mean()
+	}
+}
+
+func BenchmarkStandardDeviation(b *testing.B) {
+	e := &Epoch{Records: noisyRecords(rand.New(rand.NewSource(1)), 300, [3]float64{0.1, -0.2, 9.81}, 0.01)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.StandardDeviation(false)
+	}
+}
+
+func BenchmarkGetEpochs(b *testing.B) {
+	records := noisyRecords(rand.New(rand.NewSource(1)), 600000, [3]float64{0.1, -0.2, 9.81}, 0.01)
+	cfg := testConfig(300, 0.05)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetEpochs(records, cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPreProcessEpochs(b *testing.B) {
+	epochs := benchmarkEpochs()
+	cfg := testConfig(300, 0.05)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := PreProcessEpochs(context.Background(), epochs, cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEmptyEpoch(t *testing.T) {
+	empty := &Epoch{Records: []*Record{}}
+
+	x, y, z := empty.Mean()
+	if !math.IsNaN(x) || !math.IsNaN(y) || !math.IsNaN(z) {
+		t.Errorf("mean of an empty epoch = (%g, %g, %g), want NaN", x, y, z)
+	}
+
+	epochs := []*Epoch{empty, {Records: recordsOf([3]float64{0, 0, 9.81}, [3]float64{0, 0, 9.81})}, empty}
+	cfg := testConfig(2, 1)
+
+	retained, err := PreProcessEpochs(context.Background(), epochs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retained) != 1 || retained[0] != epochs[1] {
+		t.Errorf("retained %d epochs, want only the non-empty one", len(retained))
+	}
+
+	summaries := SummarizeEpochs(epochs, cfg)
+	if len(summaries) != 1 || summaries[0].Index != 1 {
+		t.Errorf("got %d summaries, want one of epoch 1", len(summaries))
+	}
+
+	if _, _, err := ICP(context.Background(), []*Epoch{empty}, cfg); !errors.Is(err, ErrNoEpochs) {
+		t.Errorf("ICP of an empty epoch returned %v, want ErrNoEpochs", err)
+	}
+}
+
+func TestPreProcessEpochsWorkers(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	epochs := make([]*Epoch, 0, 100)
+	for i := 0; i < cap(epochs); i++ {
+		// Every third epoch is too noisy to retain
+		noise := 0.01
+		if i%3 == 0 {
+			noise = 1
+		}
+		epochs = append(epochs, &Epoch{Records: noisyRecords(rng, 50, [3]float64{0, 0, 9.81}, noise)})
+	}
+
+	cfg := testConfig(50, 0.05)
+	cfg.Workers = 1
+	serial, err := PreProcessEpochs(context.Background(), epochs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Workers = 8
+	parallel, err := PreProcessEpochs(context.Background(), epochs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(serial) != 66 || len(parallel) != len(serial) {
+		t.Fatalf("retained %d epochs serially and %d in parallel, want 66", len(serial), len(parallel))
+	}
+	for i := range serial {
+		if serial[i] != parallel[i] {
+			t.Errorf("retained epoch %d differs between serial and parallel runs", i)
+		}
+	}
+}
+
+func TestPreProcessEpochsProgress(t *testing.T) {
+	epochs := benchmarkEpochs()[:200]
+	epochs = append(epochs, &Epoch{})
+
+	var reported []int
+	cfg := testConfig(300, 0.05)
+	cfg.Workers = 8
+	cfg.Progress = func(stage string, done, total int) {
+		if stage == "epochs" {
+			reported = append(reported, done)
+		}
+	}
+
+	if _, err := PreProcessEpochs(context.Background(), epochs, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reported) != len(epochs) {
+		t.Fatalf("reported progress %d times, want once per epoch", len(reported))
+	}
+	for i, done := range reported {
+		if done != i+1 {
+			t.Fatalf("report %d counted %d epochs done, want %d", i, done, i+1)
+		}
+	}
+}
+
+func BenchmarkPreProcessEpochsWorkers(b *testing.B) {
+	epochs := benchmarkEpochs()
+	for _, bench := range []struct {
+		name    string
+		workers int
+	}{
+		{"serial", 1},
+		{"parallel", runtime.NumCPU()},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			cfg := testConfig(300, 0.05)
+			cfg.Workers = bench.workers
+			for i := 0; i < b.N; i++ {
+				if _, err := PreProcessEpochs(context.Background(), epochs, cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestStandardDeviationConstantIsZero(t *testing.T) {
+	// Values that are not exact in binary, repeated often enough for
+	// rounding errors to accumulate
+	for _, v := range [][3]float64{{0.1, 0.2, 0.3}, {9.81, -9.81, 1e-7}, {1e6 + 0.1, -123.456, 2.0 / 3}} {
+		values := make([][3]float64, 10000)
+		for i := range values {
+			values[i] = v
+		}
+		e := &Epoch{Records: recordsOf(values...)}
+
+		for _, sample := range []bool{false, true} {
+			x, y, z := e.StandardDeviation(sample)
+			if x != 0 || y != 0 || z != 0 {
+				t.Errorf("SD of constant %v (sample %t) = (%g, %g, %g), want exactly 0", v, sample, x, y, z)
+			}
+		}
+
+		retained, err := PreProcessEpochs(context.Background(), []*Epoch{e}, testConfig(len(values), 1e-12))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(retained) != 1 {
+			t.Errorf("constant epoch %v was not retained", v)
+		}
+	}
+}
+
+// Returns the mean and population or sample variance of values in two
+// passes, for comparison with welford
+func naiveStats(values []float64, sample bool) (float64, float64) {
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	n := float64(len(values))
+	if sample {
+		n--
+	}
+
+	return mean, sum / n
+}
+
+func TestWelfordMatchesNaive(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]float64, 1000)
+	for i := range random {
+		random[i] = 9.81 + 0.05*rng.NormFloat64()
+	}
+
+	tests := []struct {
+		name   string
+		values []float64
+	}{
+		{"known values", []float64{2, 4, 4, 4, 5, 5, 7, 9}},
+		{"random", random},
+		// A one-pass sum of squares loses all digits of the variance here
+		{"large offset", []float64{1e9 + 4, 1e9 + 7, 1e9 + 13, 1e9 + 16}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var w welford
+			for _, v := range test.values {
+				w.add(v)
+			}
+
+			for _, sample := range []bool{false, true} {
+				mean, variance := naiveStats(test.values, sample)
+				if !closeTo(w.mean, mean, 1e-12*math.Abs(mean)) {
+					t.Errorf("mean = %g, want %g", w.mean, mean)
+				}
+				if got := w.variance(sample); !closeTo(got, variance, 1e-9*variance) {
+					t.Errorf("variance (sample %t) = %g, want %g", sample, got, variance)
+				}
+			}
+		})
+	}
+
+	// The population variance of 4, 7, 13 and 16 is 22.5
+	var w welford
+	for _, v := range tests[2].values {
+		w.add(v)
+	}
+	if got := w.variance(false); !closeTo(got, 22.5, 1e-6) {
+		t.Errorf("variance with a large offset = %g, want 22.5", got)
+	}
+}
+
+func TestGetEpochsEmptyInput(t *testing.T) {
+	for _, records := range [][]*Record{nil, {}} {
+		epochs, err := GetEpochs(records, testConfig(3, 1))
+		if !errors.Is(err, ErrNoData) {
+			t.Errorf("got error %v, want ErrNoData", err)
+		}
+		if epochs != nil {
+			t.Errorf("got %d epochs, want none", len(epochs))
+		}
+	}
+}
+
+func TestGetEpochsZeroSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		seconds float64
+	}{
+		{"zero rate", 0, 10},
+		{"zero length", 30, 0},
+		{"shorter than a record", 30, 0.01},
+		{"negative rate", -30, 10},
+	}
+
+	records := recordsOf([3]float64{0, 0, 9.81}, [3]float64{0, 0, 9.81})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(1, 1)
+			cfg.RecordsPerSecond = test.rate
+			cfg.EpochSeconds = test.seconds
+
+			// An epoch of no records would never advance the window
+			done := make(chan error, 1)
+			go func() {
+				_, err := GetEpochs(records, cfg)
+				done <- err
+			}()
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, ErrInvalidConfig) {
+					t.Errorf("got error %v, want ErrInvalidConfig", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("GetEpochs did not return")
+			}
+
+			if err := cfg.Validate(); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Validate returned %v, want ErrInvalidConfig", err)
+			}
+		})
+	}
+}
+
+// Returns records at the given timestamps
+func timedRecords(times ...float64) []*Record {
+	records := make([]*Record, 0, len(times))
+	for _, t := range times {
+		records = append(records, &Record{AccZ: 9.81, Time: t})
+	}
+
+	return records
+}
+
+func TestSplitAtGaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []*Record
+		maxGap  float64
+		// Number of records of each segment
+		want []int
+	}{
+		{"no records", nil, 1, []int{0}},
+		{"single record", timedRecords(5), 1, []int{1}},
+		{"no gap", timedRecords(0, 1, 2, 3), 1, []int{4}},
+		{"gap of exactly MaxGap", timedRecords(0, 0.5, 1.5, 2), 1, []int{4}},
+		{"gap just above MaxGap", timedRecords(0, 0.5, 1.5000001, 2), 1, []int{2, 2}},
+		{"several gaps", timedRecords(0, 3, 3.5, 10, 10.25), 2, []int{1, 2, 2}},
+		{"gap before the last record", timedRecords(0, 0.1, 0.2, 0.5), 0.25, []int{3, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			segments := splitAtGaps(test.records, test.maxGap)
+			if len(segments) != len(test.want) {
+				t.Fatalf("got %d segments, want %d", len(segments), len(test.want))
+			}
+
+			next := 0
+			for i, s := range segments {
+				if len(s) != test.want[i] {
+					t.Errorf("segment %d holds %d records, want %d", i, len(s), test.want[i])
+				}
+				for _, r := range s {
+					if next >= len(test.records) || r != test.records[next] {
+						t.Fatalf("segment %d does not continue the records in order", i)
+					}
+					next++
+				}
+			}
+		})
+	}
+}
+
+func TestMAD(t *testing.T) {
+	// 1, 1, 2, 2, 4, 6, 9 has median 2 and absolute deviations 1, 1, 0, 0,
+	// 2, 4, 7, whose median is 1
+	var known [][3]float64
+	for _, v := range []float64{9, 1, 4, 2, 6, 1, 2} {
+		known = append(known, [3]float64{v, -v, 10 * v})
+	}
+
+	nan := math.NaN()
+	tests := []struct {
+		name    string
+		records []*Record
+		want    [3]float64
+	}{
+		{"single record", recordsOf([3]float64{1, 2, 3}), [3]float64{0, 0, 0}},
+		{"known values", recordsOf(known...), [3]float64{1, 1, 10}},
+		{"even count", recordsOf([3]float64{1, 0, 0}, [3]float64{2, 0, 0}, [3]float64{3, 0, 0}, [3]float64{4, 0, 0}), [3]float64{1, 0, 0}},
+		{"spike", recordsOf([3]float64{5, 5, 5}, [3]float64{5, 5, 5}, [3]float64{5, 5, 5}, [3]float64{100, 5, 5}), [3]float64{0, 0, 0}},
+		{"missing value", recordsOf([3]float64{1, 1, nan}, [3]float64{3, 2, nan}, [3]float64{nan, 4, nan}), [3]float64{1, 1, nan}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Epoch{Records: test.records}
+			x, y, z := e.MAD()
+			for k, got := range [3]float64{x, y, z} {
+				if !closeTo(got, test.want[k], 1e-12) && !(math.IsNaN(got) && math.IsNaN(test.want[k])) {
+					t.Errorf("MAD %c = %g, want %g", axes[k], got, test.want[k])
+				}
+			}
+		})
+	}
+}
+
+func TestStuckRuns(t *testing.T) {
+	a, b, missing := [3]float64{0.1, 0.2, 9.8}, [3]float64{0.1, 0.2, 9.7}, [3]float64{0.1, math.NaN(), 9.8}
+
+	// A value repeated n times
+	type run struct {
+		v [3]float64
+		n int
+	}
+	runs := func(runs ...run) []*Record {
+		var values [][3]float64
+		for _, r := range runs {
+			for i := 0; i < r.n; i++ {
+				values = append(values, r.v)
+			}
+		}
+		return recordsOf(values...)
+	}
+
+	const stuckRun = 4
+	tests := []struct {
+		name    string
+		records []*Record
+		want    int
+	}{
+		{"no records", nil, 0},
+		{"one short of StuckRun", runs(run{a, stuckRun - 1}, run{b, 1}), 0},
+		{"exactly StuckRun", runs(run{b, 1}, run{a, stuckRun}, run{b, 1}), 1},
+		{"exactly StuckRun at the end", runs(run{b, 1}, run{a, stuckRun}), 1},
+		{"longer than StuckRun", runs(run{a, 3 * stuckRun}), 1},
+		{"two runs", runs(run{a, stuckRun}, run{b, stuckRun}), 2},
+		{"interrupted run", runs(run{a, stuckRun - 1}, run{b, 1}, run{a, stuckRun - 1}), 0},
+		{"missing values", runs(run{missing, 2 * stuckRun}), 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Epoch{Records: test.records}
+			if got := e.stuckRuns(stuckRun); got != test.want {
+				t.Errorf("got %d stuck runs, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOverlapWeights(t *testing.T) {
+	tests := []struct {
+		name         string
+		size, stride int
+	}{
+		{"no overlap", 10, 0},
+		{"stride of the epoch size", 10, 10},
+		{"half overlap", 10, 5},
+		{"quarter stride", 12, 3},
+		{"third stride", 12, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(test.size, 1)
+			cfg.Stride = test.stride
+			cfg.DropPartial = true
+			epochs, err := GetEpochs(recordsOf(make([][3]float64, 10*test.size)...), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stride := test.stride
+			if stride == 0 {
+				stride = test.size
+			}
+			interior := float64(stride) / float64(test.size)
+
+			// Records near either end are shared by fewer epochs, which
+			// weighs the epochs holding them more
+			weights := overlapWeights(epochs)
+			for i, w := range weights {
+				if w < interior-1e-12 || w > 1 {
+					t.Errorf("epoch %d has weight %g, outside %g to 1", i, w, interior)
+				}
+			}
+			if w := weights[len(weights)/2]; !closeTo(w, interior, 1e-12) {
+				t.Errorf("got weight %g in the middle of the run, want Stride/EpochSize = %g", w, interior)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package acc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of the errors returned by the package, to be tested with errors.Is.
+// The errors themselves carry a more specific message.
+var (
+	// A Config or options struct holds an invalid setting
+	ErrInvalidConfig = errors.New("invalid configuration")
+
+	// The input holds no records to work with
+	ErrNoData = errors.New("no data")
+
+	// The input is malformed
+	ErrParse = errors.New("parse error")
+
+	// There are no epochs, or too few, to work with
+	ErrNoEpochs = errors.New("no epochs")
+
+	// An epoch holds an unexpected number of records, with Config.Strict
+	ErrEpochSize = errors.New("unexpected epoch size")
+
+	// The fitted corrections lie outside the plausible bounds, with
+	// Config.Strict
+	ErrImplausible = errors.New("implausible corrections")
+
+	// A set of corrections is malformed or incomplete
+	ErrInvalidCorrections = errors.New("invalid corrections")
+)
+
+// An error of one of the kinds above. Its message is that of err, which may
+// wrap further errors.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *kindError) Unwrap() error {
+	return errors.Unwrap(e.err)
+}
+
+// Returns an error of the given kind formatted like fmt.Errorf, so that %w
+// verbs wrap their operands
+func errorf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,100 @@
+package acc
+
+import "math"
+
+// Returns the records with each axis replaced by its moving average over a
+// centred window of n records, which is truncated at the ends. The records
+// are returned unchanged if n <= 1. Smoothing lowers the SD of each epoch,
+// by about a factor of sqrt(n) for white noise, so the thresholds must be
+// lowered accordingly. Missing values stay missing and are left out of the
+// averages of their neighbours. Time, Temp and Orientation are kept.
+func Smooth(records []*Record, n int) []*Record {
+	if n <= 1 || len(records) == 0 {
+		return records
+	}
+
+	// Prefix sums and counts of the values of each axis, so each window is
+	// averaged in constant time. Missing values are left out of both.
+	sums := make([][3]float64, len(records)+1)
+	counts := make([][3]int, len(records)+1)
+	for i, r := range records {
+		for k, v := range [3]float64{r.AccX, r.AccY, r.AccZ} {
+			sums[i+1][k], counts[i+1][k] = sums[i][k], counts[i][k]
+			if !math.IsNaN(v) {
+				sums[i+1][k] += v
+				counts[i+1][k]++
+			}
+		}
+	}
+
+	smoothed := make([]*Record, 0, len(records))
+	for i, r := range records {
+		lo := i - (n-1)/2
+		if lo < 0 {
+			lo = 0
+		}
+		hi := i + n/2 + 1
+		if hi > len(records) {
+			hi = len(records)
+		}
+
+		var avg [3]float64
+		for k, v := range [3]float64{r.AccX, r.AccY, r.AccZ} {
+			avg[k] = math.NaN()
+			if !math.IsNaN(v) {
+				avg[k] = (sums[hi][k] - sums[lo][k]) / float64(counts[hi][k]-counts[lo][k])
+			}
+		}
+
+		smoothed = append(smoothed, &Record{
+			AccX: avg[0],
+			AccY: avg[1],
+			AccZ: avg[2],
+			Time: r.Time,
+			Temp: r.Temp,
+
+			Orientation: r.Orientation,
+		})
+	}
+
+	return smoothed
+}
+
+// Returns the records with the mean of each axis across all records
+// subtracted, leaving the variation around it. This removes gravity along
+// with the offsets, so centred records cannot be calibrated. Missing values
+// stay missing. Time, Temp and Orientation are kept.
+func Center(records []*Record) []*Record {
+	if len(records) == 0 {
+		return records
+	}
+
+	var mean [3]float64
+	var counts [3]int
+	for _, r := range records {
+		for k, v := range [3]float64{r.AccX, r.AccY, r.AccZ} {
+			if !math.IsNaN(v) {
+				mean[k] += v
+				counts[k]++
+			}
+		}
+	}
+	for k := range mean {
+		mean[k] /= float64(counts[k])
+	}
+
+	centred := make([]*Record, 0, len(records))
+	for _, r := range records {
+		centred = append(centred, &Record{
+			AccX: r.AccX - mean[0],
+			AccY: r.AccY - mean[1],
+			AccZ: r.AccZ - mean[2],
+			Time: r.Time,
+			Temp: r.Temp,
+
+			Orientation: r.Orientation,
+		})
+	}
+
+	return centred
+}
@@ -0,0 +1,38 @@
+package acc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSmooth(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name    string
+		records []*Record
+		n       int
+		want    []float64
+	}{
+		{"unchanged", seriesRecords(1, 5, 2), 1, []float64{1, 5, 2}},
+		{"no records", nil, 3, []float64{}},
+		{"centred window", seriesRecords(1, 2, 3, 4, 5), 3, []float64{1.5, 2, 3, 4, 4.5}},
+		{"even window", seriesRecords(1, 2, 3, 4, 5), 2, []float64{1.5, 2.5, 3.5, 4.5, 5}},
+		{"wider than the records", seriesRecords(1, 2, 3), 7, []float64{2, 2, 2}},
+		{"spike", seriesRecords(0, 0, 9, 0, 0), 3, []float64{0, 3, 3, 3, 0}},
+		{"missing value", seriesRecords(1, nan, 3), 3, []float64{1, nan, 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			smoothed := Smooth(test.records, test.n)
+			if !matchesSeries(smoothed, test.want) {
+				t.Fatalf("got X values %v, want %v", xValues(smoothed), test.want)
+			}
+			for i, r := range smoothed {
+				if r.Time != float64(i) {
+					t.Errorf("record %d has timestamp %g, want %d", i, r.Time, i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,617 @@
+package acc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Correction of a single axis: corrected = Gain*raw + Offset. In the full
+// model the axis is corrected as Matrix . (rawX, rawY, rawZ) + Offset.
+type Correction struct {
+	Axis rune
+
+	// Offset d
+	Offset float64
+
+	// Gain factor a
+	Gain float64
+
+	// Row of the scale and misalignment matrix for this axis in the full
+	// model, nil in the simple model. Gain equals the diagonal element.
+	Matrix []float64
+
+	// Change of the offset per degree of sensor temperature when fitted
+	// with temperature compensation, zero otherwise. The offset at
+	// temperature T is Offset + TempSlope*(T - RefTemp).
+	TempSlope float64
+
+	// Temperature at which Offset applies, the mean temperature of the
+	// fitted epochs with temperature compensation and zero otherwise
+	RefTemp float64
+}
+
+// Selects the norm ICP weights epochs by
+type NormKind string
+
+const (
+	// Norm of the epoch's mean vector. Opposing transients within the epoch
+	// cancel out.
+	NormOfMean NormKind = "norm-of-mean"
+
+	// Mean of the norms of the epoch's records. Transients in any direction
+	// increase the norm, so moving epochs are trusted less.
+	MeanOfNorms NormKind = "mean-of-norms"
+)
+
+// Selects the parameters fitted by ICP
+type ModelKind string
+
+const (
+	// Per-axis offset and gain, 6 parameters
+	ModelSimple ModelKind = "simple"
+
+	// Lower triangular 3x3 scale and misalignment matrix plus offset, 9
+	// parameters
+	ModelFull ModelKind = "full"
+)
+
+// Selects the quantity whose change between iterations terminates ICP once
+// it falls below Config.Tolerance
+type ConvergenceKind string
+
+const (
+	// Largest change of any fitted parameter. Offsets are in m/s² but gains
+	// are unitless, so the tolerance weighs them differently.
+	ConvergeParam ConvergenceKind = "param"
+
+	// Change of the RMSE of the corrected epoch norms from gravity, which is
+	// independent of the scale of the individual parameters
+	ConvergeRMSE ConvergenceKind = "rmse"
+)
+
+const (
+	// Points whose norm is within epsilon of gravity are considered on the sphere
+	epsilon = 1e-9
+
+	// Smallest range of the epochs' mean temperatures, in degrees, over
+	// which temperature slopes are fitted. Over a narrower range a slope
+	// mostly follows the noise of the offsets.
+	minTempSpread = 1.0
+
+	maxWeight = 100
+)
+
+var axes = [3]rune{'X', 'Y', 'Z'}
+
+// Describes how well ICP fit the epochs
+type Diagnostics struct {
+	// Root-mean-square of ||a*mean + d|| - gravity across the epochs
+	RMSE float64 `json:"rmse"`
+
+	// Number of iterations run
+	Iterations int `json:"iterations"`
+
+	// Whether the change selected by Config.ConvergeOn dropped below
+	// Config.Tolerance
+	Converged bool `json:"converged"`
+
+	// Number of weighted least-squares passes run over all iterations
+	Refinements int `json:"refinements"`
+
+	// Rank of the regression of the epoch means, out of 4 for an offset
+	// and a gain per axis or fewer if axes are fixed, and its condition
+	// number, which is zero when the rank is deficient. Large values mean
+	// the epochs span too few orientations to tell offsets from gains.
+	Rank      int     `json:"rank"`
+	Condition float64 `json:"condition"`
+
+	// Reasons the corrections lie outside the bounds of Config.MinGain,
+	// MaxGain and MaxOffset, empty if they lie within them. Set only when
+	// Config.Strict is not, as the calibration fails otherwise.
+	Implausible []string `json:"implausible,omitempty"`
+}
+
+// Relative size below which a singular value counts as zero
+const rankTolerance = 1e-6
+
+// Returns the rank and condition number of the design matrix [1, m/gravity]
+// of the epoch means m, restricted to the axes that are not fixed, or a zero
+// condition number if the rank is deficient. The means are scaled by gravity
+// so that all columns are of order one.
+func designCondition(means [][3]float64, gravity float64, fixed [3]bool) (int, float64) {
+	cols := []int{}
+	for k := range fixed {
+		if !fixed[k] {
+			cols = append(cols, k)
+		}
+	}
+
+	A := make([][]float64, len(cols)+1)
+	for i := range A {
+		A[i] = make([]float64, len(cols)+1)
+	}
+	for _, m := range means {
+		x := []float64{1}
+		for _, k := range cols {
+			x = append(x, m[k]/gravity)
+		}
+		for j := range x {
+			for k := range x {
+				A[j][k] += x[j] * x[k]
+			}
+		}
+	}
+
+	// The singular values of the design matrix are the square roots of the
+	// eigenvalues of its normal matrix
+	eigen := symmetricEigenvalues(A)
+	max := 0.0
+	for _, v := range eigen {
+		max = math.Max(max, v)
+	}
+	if max <= 0 {
+		return 0, 0
+	}
+
+	rank := 0
+	min := max
+	for _, v := range eigen {
+		if math.Sqrt(math.Max(v, 0)/max) > rankTolerance {
+			rank++
+			min = math.Min(min, v)
+		}
+	}
+	if rank < len(eigen) {
+		return rank, 0
+	}
+
+	return rank, math.Sqrt(max / min)
+}
+
+// Returns the ICP weight of a point with the given norm: the inverse of its
+// distance to the sphere of radius gravity, capped at maxWeight. Points
+// lying on the sphere get maxWeight instead of dividing by zero.
+func epochWeight(norm, gravity float64) float64 {
+	return distanceWeight(math.Abs(norm - gravity))
+}
+
+// Returns the inverse of dist, capped at maxWeight
+func distanceWeight(dist float64) float64 {
+	if dist < epsilon {
+		return maxWeight
+	}
+
+	weight := 1 / dist
+	if weight >= maxWeight {
+		return maxWeight
+	}
+
+	return weight
+}
+
+// Fits per-axis offsets d and gains a such that the corrected epoch means
+// a*mean + d lie on the sphere of radius cfg.Gravity. With ModelFull, a is a
+// lower triangular 3x3 matrix. Each iteration projects the
+// corrected means onto the sphere (the closest points) and solves a
+// weighted least-squares regression of the closest points on the raw means
+// per axis. Epochs whose records share a known orientation are fitted to
+// the reading expected at rest in it instead. Points far from the sphere are
+// down-weighted. Iteration stops when the quantity selected by
+// cfg.ConvergeOn changes by less than cfg.Tolerance, or after
+// cfg.Iterations. Returns the context's error if it is cancelled.
+func ICP(ctx context.Context, epochs []*Epoch, cfg Config) ([]*Correction, *Diagnostics, error) {
+	return icp(ctx, epochs, cfg, false)
+}
+
+// Runs ICP, logging its outcome and warnings at debug level only if quiet
+// is set, for callers that fit repeatedly and report the Diagnostics
+// themselves
+func icp(ctx context.Context, epochs []*Epoch, cfg Config, quiet bool) ([]*Correction, *Diagnostics, error) {
+	infof, warnf := log.Infof, log.Warnf
+	if quiet {
+		infof, warnf = log.Debugf, log.Debugf
+	}
+
+	nIterations := cfg.Iterations
+	gravity := cfg.Gravity
+
+	// An unknown kind would otherwise select the alternative of every
+	// comparison below
+	if err := cfg.validateKinds(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(epochs) == 0 {
+		return nil, nil, errorf(ErrNoEpochs, "No epochs to iterate")
+	}
+
+	means := make([][3]float64, 0, len(epochs))
+	temps := make([]float64, 0, len(epochs))
+	nonEmpty := make([]*Epoch, 0, len(epochs))
+
+	// Readings expected at rest of the epochs with a known orientation,
+	// which ICP fits them to instead of the nearest point on the sphere
+	targets := make([][3]float64, 0, len(epochs))
+	known := make([]bool, 0, len(epochs))
+	for _, e := range epochs {
+		if len(e.Records) == 0 {
+			continue
+		}
+
+		x, y, z := e.Mean()
+		means = append(means, [3]float64{x, y, z})
+		temps = append(temps, e.meanTemp())
+		target, ok := e.orientation().target(gravity)
+		if ok && angle([3]float64{x, y, z}, target) > labelAngle {
+			warnf("Epoch with mean (%f, %f, %f) is more than %.0f° from its labelled orientation %s", x, y, z, labelAngle, e.orientation())
+		}
+		targets = append(targets, target)
+		known = append(known, ok)
+		nonEmpty = append(nonEmpty, e)
+	}
+	if len(means) == 0 {
+		return nil, nil, errorf(ErrNoEpochs, "No epochs to iterate")
+	}
+
+	// The temperatures are centred on their mean, at which the fitted
+	// offsets apply, so that the offsets do not depend on the slopes
+	tempComp := cfg.TempCompensation
+	refTemp := 0.0
+	if tempComp {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, t := range temps {
+			lo, hi = math.Min(lo, t), math.Max(hi, t)
+			refTemp += t / float64(len(temps))
+		}
+		if hi-lo < minTempSpread {
+			warnf("The epoch temperatures span only %g degrees, too little to fit temperature slopes. Fitting constant offsets instead.", hi-lo)
+			tempComp = false
+		}
+		for i := range temps {
+			temps[i] -= refTemp
+		}
+	}
+
+	// Factors of the weights that undo the double-counting of records in
+	// overlapping epochs, all 1 unless cfg.OverlapWeighting is set
+	overlap := make([]float64, len(means))
+	for i := range overlap {
+		overlap[i] = 1
+	}
+	if cfg.OverlapWeighting {
+		overlap = overlapWeights(nonEmpty)
+	}
+
+	d := [3]float64{0, 0, 0}
+	a := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+	// Temperature slopes of the offsets, only fitted with
+	// cfg.TempCompensation. offsets holds d + slopes*T for each epoch, with
+	// T relative to refTemp.
+	slopes := [3]float64{0, 0, 0}
+	offsets := epochOffsets(nil, d, slopes, temps)
+
+	weights := make([]float64, len(means))
+	copy(weights, overlap)
+
+	closest := make([][3]float64, len(means))
+	xs := make([][]float64, len(means))
+	ys := make([]float64, len(means))
+
+	prevRMSE := rmse(means, offsets, a, gravity)
+	inner := cfg.InnerIterations
+	if inner < 1 {
+		inner = 1
+	}
+
+	converged := false
+	iter := 0
+	refinements := 0
+	for iter < nIterations {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		iter++
+		cfg.progress("icp", iter, nIterations)
+
+		for i, m := range means {
+			if known[i] {
+				closest[i] = targets[i]
+				continue
+			}
+
+			curr := correct(m, offsets[i], a)
+			norm := euclidean(curr)
+			for k := 0; k < 3; k++ {
+				if norm == 0 {
+					closest[i][k] = curr[k]
+				} else {
+					closest[i][k] = curr[k] / norm * gravity
+				}
+			}
+		}
+
+		// Reweighted passes over the same closest points. The arrays are
+		// copied, so the change is measured across all passes.
+		prevD, prevA, prevSlopes := d, a, slopes
+		for pass := 0; pass < inner; pass++ {
+			refinements++
+			for k := 0; k < 3; k++ {
+				// A fixed axis keeps offset 0 and gain 1
+				if cfg.FixedAxes[k] {
+					continue
+				}
+
+				// Columns of a that are fitted for axis k. In the full model a
+				// is lower triangular, which removes the rotational freedom of
+				// a sphere fit without losing any misalignment terms. Fixed
+				// axes are left out so that their readings do not leak into
+				// the fitted ones.
+				cols := []int{k}
+				if cfg.Model == ModelFull {
+					cols = []int{}
+					for j := 0; j <= k; j++ {
+						if !cfg.FixedAxes[j] {
+							cols = append(cols, j)
+						}
+					}
+				}
+
+				for i, m := range means {
+					xs[i] = []float64{1}
+					for _, j := range cols {
+						xs[i] = append(xs[i], m[j])
+					}
+					if tempComp {
+						xs[i] = append(xs[i], temps[i])
+					}
+					ys[i] = closest[i][k]
+				}
+
+				coef, err := weightedLeastSquares(xs, ys, weights)
+				if err != nil {
+					// The epochs do not span enough orientations, so only the
+					// offset can be estimated
+					coef = []float64{weightedMean(closest, means, weights, k, a[k], slopes[k], temps)}
+					for _, j := range cols {
+						coef = append(coef, a[k][j])
+					}
+					if tempComp {
+						coef = append(coef, slopes[k])
+					}
+				}
+
+				d[k] = coef[0]
+				for c, j := range cols {
+					a[k][j] = coef[c+1]
+				}
+				if tempComp {
+					slope := coef[len(cols)+1]
+					slopes[k] = slope
+				}
+			}
+			offsets = epochOffsets(offsets, d, slopes, temps)
+
+			for i, m := range means {
+				// An epoch of known orientation is weighted by its distance to
+				// the expected reading, of which its norm is only one part
+				if known[i] {
+					curr := correct(m, offsets[i], a)
+					weights[i] = overlap[i] * distanceWeight(euclidean([3]float64{curr[0] - targets[i][0], curr[1] - targets[i][1], curr[2] - targets[i][2]}))
+					continue
+				}
+
+				if cfg.WeightNorm == MeanOfNorms {
+					weights[i] = overlap[i] * epochWeight(nonEmpty[i].correctedMeanNorm(offsets[i], a), gravity)
+				} else {
+					weights[i] = overlap[i] * epochWeight(euclidean(correct(m, offsets[i], a)), gravity)
+				}
+			}
+		}
+		change := paramChange(prevD, d, prevA, a, prevSlopes, slopes)
+
+		debug := log.IsLevelEnabled(log.DebugLevel)
+		curr := 0.0
+		if cfg.ConvergeOn == ConvergeRMSE || debug {
+			curr = rmse(means, offsets, a, gravity)
+		}
+		if cfg.ConvergeOn == ConvergeRMSE {
+			change = math.Abs(curr - prevRMSE)
+			prevRMSE = curr
+		}
+
+		if debug {
+			line := fmt.Sprintf("ICP iteration %d: d X: %f Y: %f Z: %f", iter, d[0], d[1], d[2])
+			if cfg.Model == ModelFull {
+				line += fmt.Sprintf("\ta X: %f %f %f Y: %f %f %f Z: %f %f %f",
+					a[0][0], a[0][1], a[0][2], a[1][0], a[1][1], a[1][2], a[2][0], a[2][1], a[2][2])
+			} else {
+				line += fmt.Sprintf("\ta X: %f Y: %f Z: %f", a[0][0], a[1][1], a[2][2])
+			}
+			if tempComp {
+				line += fmt.Sprintf("\tslope X: %f Y: %f Z: %f", slopes[0], slopes[1], slopes[2])
+			}
+			log.Debugf("%s\tRMSE: %f\tchange: %g", line, curr, change)
+		}
+
+		if change < cfg.Tolerance {
+			converged = true
+			break
+		}
+	}
+
+	if converged {
+		infof("ICP converged after %d iterations and %d least-squares passes", iter, refinements)
+	} else {
+		warnf("ICP did not converge within %d iterations", nIterations)
+	}
+
+	corrections := make([]*Correction, 0, 3)
+	for k, axis := range axes {
+		c := &Correction{
+			Axis:      axis,
+			Offset:    d[k],
+			Gain:      a[k][k],
+			TempSlope: slopes[k],
+			RefTemp:   refTemp,
+		}
+		if cfg.Model == ModelFull {
+			c.Matrix = []float64{a[k][0], a[k][1], a[k][2]}
+		}
+		corrections = append(corrections, c)
+	}
+
+	diag := &Diagnostics{
+		RMSE:       rmse(means, offsets, a, gravity),
+		Iterations: iter,
+		Converged:  converged,
+
+		Refinements: refinements,
+	}
+
+	full := 4
+	for _, f := range cfg.FixedAxes {
+		if f {
+			full--
+		}
+	}
+	diag.Rank, diag.Condition = designCondition(means, gravity, cfg.FixedAxes)
+	if diag.Condition == 0 || (cfg.MaxCondition > 0 && diag.Condition > cfg.MaxCondition) {
+		warnf("The epochs span too few orientations to tell offsets from gains (rank %d of %d, condition number %.1f), so the corrections may be unreliable. Record the device in more varied orientations.",
+			diag.Rank, full, diag.Condition)
+	}
+
+	return corrections, diag, nil
+}
+
+// Returns the mean norm of the epoch's records corrected as a*r + d,
+// skipping records with a missing value
+func (e *Epoch) correctedMeanNorm(d [3]float64, a [3][3]float64) float64 {
+	sum := 0.0
+	n := 0
+	for _, r := range e.Records {
+		if r.incomplete() {
+			continue
+		}
+		sum += euclidean(correct([3]float64{r.AccX, r.AccY, r.AccZ}, d, a))
+		n++
+	}
+
+	return sum / float64(n)
+}
+
+// Returns d + slopes*T for each of the temperatures T, reusing offsets if it
+// is large enough
+func epochOffsets(offsets [][3]float64, d, slopes [3]float64, temps []float64) [][3]float64 {
+	if len(offsets) < len(temps) {
+		offsets = make([][3]float64, len(temps))
+	}
+
+	for i, t := range temps {
+		for k := 0; k < 3; k++ {
+			offsets[i][k] = d[k] + slopes[k]*t
+		}
+	}
+
+	return offsets
+}
+
+// Returns the root-mean-square distance of the means, each corrected with
+// its own offset, to the sphere of radius gravity
+func rmse(means, offsets [][3]float64, a [3][3]float64, gravity float64) float64 {
+	sum := 0.0
+	for i, m := range means {
+		r := euclidean(correct(m, offsets[i], a)) - gravity
+		sum += r * r
+	}
+
+	return math.Sqrt(sum / float64(len(means)))
+}
+
+// Returns the largest absolute difference between the old and new offsets,
+// matrix entries and temperature slopes
+func paramChange(oldD, d [3]float64, oldA, a [3][3]float64, oldSlopes, slopes [3]float64) float64 {
+	change := 0.0
+	for k := 0; k < 3; k++ {
+		change = math.Max(change, math.Abs(d[k]-oldD[k]))
+		change = math.Max(change, math.Abs(slopes[k]-oldSlopes[k]))
+		for j := 0; j < 3; j++ {
+			change = math.Max(change, math.Abs(a[k][j]-oldA[k][j]))
+		}
+	}
+
+	return change
+}
+
+// Returns the weighted mean of closest - row . mean - slope*T along axis k
+func weightedMean(closest, means [][3]float64, weights []float64, k int, row [3]float64, slope float64, temps []float64) float64 {
+	sum, wsum := 0.0, 0.0
+	for i, m := range means {
+		sum += weights[i] * (closest[i][k] - (row[0]*m[0] + row[1]*m[1] + row[2]*m[2]) - slope*temps[i])
+		wsum += weights[i]
+	}
+
+	return sum / wsum
+}
+
+// Returns a*m + d
+func correct(m, d [3]float64, a [3][3]float64) [3]float64 {
+	var c [3]float64
+	for k := 0; k < 3; k++ {
+		c[k] = a[k][0]*m[0] + a[k][1]*m[1] + a[k][2]*m[2] + d[k]
+	}
+
+	return c
+}
+
+func euclidean(v [3]float64) float64 {
+	return math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+}
+
+type correctionJSON struct {
+	Axis      string    `json:"axis"`
+	Offset    float64   `json:"offset"`
+	Gain      float64   `json:"gain"`
+	Matrix    []float64 `json:"matrix,omitempty"`
+	TempSlope float64   `json:"tempSlope,omitempty"`
+	RefTemp   float64   `json:"refTemp,omitempty"`
+}
+
+// Serializes the axis as a string rather than a rune code point
+func (c Correction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(correctionJSON{
+		Axis:      string(c.Axis),
+		Offset:    c.Offset,
+		Gain:      c.Gain,
+		Matrix:    c.Matrix,
+		TempSlope: c.TempSlope,
+		RefTemp:   c.RefTemp,
+	})
+}
+
+func (c *Correction) UnmarshalJSON(data []byte) error {
+	var cj correctionJSON
+	if err := json.Unmarshal(data, &cj); err != nil {
+		return err
+	}
+
+	axis := []rune(cj.Axis)
+	if len(axis) != 1 {
+		return fmt.Errorf("Invalid axis %q", cj.Axis)
+	}
+
+	*c = Correction{
+		Axis:      axis[0],
+		Offset:    cj.Offset,
+		Gain:      cj.Gain,
+		Matrix:    cj.Matrix,
+		TempSlope: cj.TempSlope,
+		RefTemp:   cj.RefTemp,
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package acc
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+)
+
+// Returns n epochs of size records each, held in random orientations by a
+// sensor reading (g - d) / a per axis with normally distributed noise of SD
+// noise, so that a*reading + d lies on the sphere of radius gravity
+func orientationEpochs(rng *rand.Rand, n, size int, d, a [3]float64, noise float64) []*Epoch {
+	epochs := make([]*Epoch, 0, n)
+	for i := 0; i < n; i++ {
+		var g [3]float64
+		for k := range g {
+			g[k] = rng.NormFloat64()
+		}
+		norm := euclidean(g)
+
+		var reading [3]float64
+		for k := range reading {
+			reading[k] = (DefaultGravity*g[k]/norm - d[k]) / a[k]
+		}
+		epochs = append(epochs, &Epoch{Records: noisyRecords(rng, size, reading, noise), Index: i})
+	}
+
+	return epochs
+}
+
+// A context that cancels itself on the n-th check of its error, so that a
+// run is cancelled after a known number of loop iterations
+type cancelAfter struct {
+	context.Context
+	cancel context.CancelFunc
+	n      int32
+	checks int32
+}
+
+func newCancelAfter(n int32) *cancelAfter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &cancelAfter{Context: ctx, cancel: cancel, n: n}
+}
+
+func (c *cancelAfter) Err() error {
+	if atomic.AddInt32(&c.checks, 1) >= c.n {
+		c.cancel()
+	}
+
+	return c.Context.Err()
+}
+
+func TestICPCancelled(t *testing.T) {
+	epochs := orientationEpochs(rand.New(rand.NewSource(1)), 40, 50, [3]float64{0.3, -0.2, 0.15}, [3]float64{1.05, 0.97, 1.02}, 0.01)
+
+	// ICP checks the context at the start of every iteration, and the tight
+	// tolerance keeps it from converging first
+	ctx := newCancelAfter(3)
+	defer ctx.cancel()
+
+	cfg := testConfig(50, 0.05)
+	cfg.Tolerance = 1e-12
+	corrections, diag, err := ICP(ctx, epochs, cfg)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if corrections != nil || diag != nil {
+		t.Error("a cancelled ICP returned results")
+	}
+	if checks := atomic.LoadInt32(&ctx.checks); checks != 3 {
+		t.Errorf("ICP checked the context %d times after being cancelled in iteration 3", checks)
+	}
+}
+
+func TestPreProcessEpochsCancelled(t *testing.T) {
+	epochs := benchmarkEpochs()
+
+	// Pre-processing checks the context once after computing the statistics
+	// and then once per epoch
+	ctx := newCancelAfter(10)
+	defer ctx.cancel()
+
+	cfg := testConfig(300, 0.05)
+	cfg.Workers = 2
+	retained, err := PreProcessEpochs(ctx, epochs, cfg)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if retained != nil {
+		t.Errorf("a cancelled pre-processing retained %d epochs", len(retained))
+	}
+}
+
+func TestCalibrateEpochsDeadline(t *testing.T) {
+	epochs := orientationEpochs(rand.New(rand.NewSource(1)), 40, 50, [3]float64{0.3, -0.2, 0.15}, [3]float64{1.05, 0.97, 1.02}, 0.01)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	_, err := CalibrateEpochs(ctx, epochs, testConfig(50, 0.05))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestICPUnknownKinds(t *testing.T) {
+	epochs := orientationEpochs(rand.New(rand.NewSource(1)), 10, 50, [3]float64{}, [3]float64{1, 1, 1}, 0.01)
+
+	tests := []struct {
+		name string
+		set  func(*Config)
+	}{
+		{"model", func(c *Config) { c.Model = "affine" }},
+		{"convergence metric", func(c *Config) { c.ConvergeOn = "" }},
+		{"weight norm", func(c *Config) { c.WeightNorm = "max" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(50, 0.05)
+			test.set(&cfg)
+
+			if err := cfg.Validate(); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Validate returned %v, want ErrInvalidConfig", err)
+			}
+			if _, _, err := ICP(context.Background(), epochs, cfg); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("ICP returned %v, want ErrInvalidConfig", err)
+			}
+		})
+	}
+}
+
+func TestICPFullModel(t *testing.T) {
+	d := [3]float64{0.3, -0.2, 0.15}
+	a := [3][3]float64{
+		{1.05, 0, 0},
+		{0.02, 0.97, 0},
+		{-0.01, 0.03, 1.02},
+	}
+
+	// Readings on the sphere, as from an ideal sensor, are mapped to what a
+	// sensor with the lower-triangular matrix a reads by forward substitution
+	// of a*reading + d = ideal
+	epochs := orientationEpochs(rand.New(rand.NewSource(1)), 60, 50, [3]float64{}, [3]float64{1, 1, 1}, 0.001)
+	for _, e := range epochs {
+		for i, r := range e.Records {
+			ideal := [3]float64{r.AccX - d[0], r.AccY - d[1], r.AccZ - d[2]}
+			var reading [3]float64
+			for k := range reading {
+				sum := ideal[k]
+				for j := 0; j < k; j++ {
+					sum -= a[k][j] * reading[j]
+				}
+				reading[k] = sum / a[k][k]
+			}
+			e.Records[i].AccX, e.Records[i].AccY, e.Records[i].AccZ = reading[0], reading[1], reading[2]
+		}
+	}
+
+	cfg := testConfig(50, 0.05)
+	cfg.Model = ModelFull
+	cfg.Tolerance = 1e-10
+	corrections, _, err := ICP(context.Background(), epochs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, c := range corrections {
+		if !closeTo(c.Offset, d[k], 1e-4) {
+			t.Errorf("axis %c: got offset %g, want %g", c.Axis, c.Offset, d[k])
+		}
+		if len(c.Matrix) != 3 {
+			t.Fatalf("axis %c: got matrix row %v, want 3 elements", c.Axis, c.Matrix)
+		}
+		for j := range c.Matrix {
+			if !closeTo(c.Matrix[j], a[k][j], 1e-4) {
+				t.Errorf("axis %c: got matrix element %d %g, want %g", c.Axis, j, c.Matrix[j], a[k][j])
+			}
+		}
+		if c.Gain != c.Matrix[k] {
+			t.Errorf("axis %c: gain %g is not the diagonal element %g", c.Axis, c.Gain, c.Matrix[k])
+		}
+	}
+}
+
+func TestICPTempCompensation(t *testing.T) {
+	d0, d1 := [3]float64{0.3, -0.2, 0.15}, [3]float64{0.004, -0.002, 0.001}
+	a := [3]float64{1.05, 0.97, 1.02}
+	const t0 = 25.0
+
+	tests := []struct {
+		name string
+		// Temperature step between consecutive epochs
+		step       float64
+		wantSlopes bool
+		// The unfitted slopes leave residuals that bias constant offsets
+		tolerance float64
+	}{
+		{"slopes", 0.5, true, 1e-4},
+		{"too narrow a span", 0.01, false, 1e-3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Ideal readings on the sphere are mapped to what a sensor with
+			// the offsets d0 + d1*(T - t0) at the epoch's temperature T reads
+			epochs := orientationEpochs(rand.New(rand.NewSource(1)), 40, 50, [3]float64{}, [3]float64{1, 1, 1}, 0.001)
+			refTemp := 0.0
+			for i, e := range epochs {
+				temp := 20 + float64(i)*test.step
+				refTemp += temp / float64(len(epochs))
+				for j, r := range e.Records {
+					r.AccX = (r.AccX - d0[0] - d1[0]*(temp-t0)) / a[0]
+					r.AccY = (r.AccY - d0[1] - d1[1]*(temp-t0)) / a[1]
+					r.AccZ = (r.AccZ - d0[2] - d1[2]*(temp-t0)) / a[2]
+					r.Temp = temp
+					e.Records[j] = r
+				}
+			}
+
+			cfg := testConfig(50, 0.05)
+			cfg.TempCompensation = true
+			cfg.Tolerance = 1e-10
+			corrections, _, err := ICP(context.Background(), epochs, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for k, c := range corrections {
+				if !closeTo(c.RefTemp, refTemp, 1e-9) {
+					t.Errorf("axis %c: got reference temperature %g, want %g", c.Axis, c.RefTemp, refTemp)
+				}
+				want := d0[k] + d1[k]*(refTemp-t0)
+				if !closeTo(c.Offset, want, test.tolerance) {
+					t.Errorf("axis %c: got offset %g, want %g", c.Axis, c.Offset, want)
+				}
+				if test.wantSlopes && !closeTo(c.TempSlope, d1[k], 1e-5) {
+					t.Errorf("axis %c: got slope %g, want %g", c.Axis, c.TempSlope, d1[k])
+				}
+				if !test.wantSlopes && c.TempSlope != 0 {
+					t.Errorf("axis %c: got slope %g, want constant offsets", c.Axis, c.TempSlope)
+				}
+				if !closeTo(c.Gain, a[k], 1e-4) {
+					t.Errorf("axis %c: got gain %g, want %g", c.Axis, c.Gain, a[k])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package acc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Controls how ReadJSONRecords interprets its input
+type JSONOptions struct {
+	// Keys of the X, Y and Z values. Default to "x", "y" and "z" when empty.
+	XKey, YKey, ZKey string
+
+	// Key of a timestamp in seconds, not read when empty
+	TimeKey string
+
+	// Key of the sensor temperature, not read when empty
+	TempKey string
+
+	// Log and skip malformed lines instead of failing
+	SkipBadRows bool
+
+	// Largest plausible magnitude of an axis value, in Units. Lines with
+	// larger values are malformed. Not checked when zero.
+	MaxAbs float64
+
+	// Stop reading once this many records have been parsed. Unlimited
+	// when zero or negative.
+	MaxRecords int
+
+	// Unit of the axis values, which are converted to m/s². Defaults to
+	// UnitMS2 when empty.
+	Units Unit
+}
+
+func (opts JSONOptions) keys() [3]string {
+	keys := [3]string{opts.XKey, opts.YKey, opts.ZKey}
+	for i, def := range []string{"x", "y", "z"} {
+		if keys[i] == "" {
+			keys[i] = def
+		}
+	}
+
+	return keys
+}
+
+// Reads the records of the newline-delimited JSON file at filePath, or of
+// stdin when filePath is empty or "-"
+func ReadJSONFile(filePath string, opts JSONOptions) ([]*Record, error) {
+	return readFile(filePath, func(in io.Reader) ([]*Record, error) {
+		return ReadJSONRecords(in, opts)
+	})
+}
+
+// Reads one record per line of newline-delimited JSON objects such as
+// {"x":0.1,"y":9.8,"z":0.0}. Blank lines are ignored. The stream may be
+// gzip-compressed. Returns an error if it holds no data lines.
+func ReadJSONRecords(in io.Reader, opts JSONOptions) ([]*Record, error) {
+	records := make([]*Record, 0)
+
+	in, err := maybeGunzip(in)
+	if err != nil {
+		return nil, errorf(ErrParse, "Invalid gzip stream: %w", err)
+	}
+
+	scanner := bufio.NewScanner(skipBOM(in))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	line := 0
+	skipped := 0
+	for (opts.MaxRecords <= 0 || len(records) < opts.MaxRecords) && scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		rec, err := parseJSONRecord([]byte(text), opts)
+		if err != nil {
+			if !opts.SkipBadRows {
+				return nil, errorf(ErrParse, "Line %d: %w", line, err)
+			}
+
+			log.Warnf("Skipping line %d: %s", line, err.Error())
+			skipped++
+			continue
+		}
+
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errorf(ErrParse, "Line %d: %w", line+1, err)
+	}
+
+	if skipped > 0 {
+		log.Warnf("Skipped %d malformed rows", skipped)
+	}
+
+	if err := checkNotEmpty(records, skipped); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func parseJSONRecord(data []byte, opts JSONOptions) (*Record, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	var values [3]float64
+	for i, key := range opts.keys() {
+		v, err := jsonFloat(fields, key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	if err := checkAxes(values, opts.MaxAbs); err != nil {
+		return nil, err
+	}
+
+	f := opts.Units.toMS2()
+	rec := &Record{
+		AccX: values[0] * f,
+		AccY: values[1] * f,
+		AccZ: values[2] * f,
+	}
+
+	if opts.TimeKey != "" {
+		t, err := jsonFloat(fields, opts.TimeKey)
+		if err != nil {
+			return nil, err
+		}
+		rec.Time = t
+	}
+
+	if opts.TempKey != "" {
+		t, err := jsonFloat(fields, opts.TempKey)
+		if err != nil {
+			return nil, err
+		}
+		rec.Temp = t
+	}
+
+	return rec, nil
+}
+
+func jsonFloat(fields map[string]json.RawMessage, key string) (float64, error) {
+	raw, ok := fields[key]
+	if !ok {
+		return 0, fmt.Errorf("missing key %q", key)
+	}
+
+	// Unmarshalling null into a float leaves it zero
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return 0, fmt.Errorf("key %q is null", key)
+	}
+
+	var v float64
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0, fmt.Errorf("key %q: invalid number %s", key, raw)
+	}
+
+	return v, nil
+}
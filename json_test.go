@@ -0,0 +1,96 @@
+package acc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadJSONRecords(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  JSONOptions
+		want  []Record
+	}{
+		{"default keys", `{"x":0.1,"y":9.8,"z":-0.2}` + "\n", JSONOptions{}, []Record{{AccX: 0.1, AccY: 9.8, AccZ: -0.2}}},
+		{
+			"custom keys",
+			`{"ax":0.1,"ay":9.8,"az":-0.2,"t":1.5,"temp":24.5,"x":7}` + "\n",
+			JSONOptions{XKey: "ax", YKey: "ay", ZKey: "az", TimeKey: "t", TempKey: "temp"},
+			[]Record{{AccX: 0.1, AccY: 9.8, AccZ: -0.2, Time: 1.5, Temp: 24.5}},
+		},
+		{
+			"blank lines",
+			"\n" + `{"x":1,"y":2,"z":3}` + "\n  \n\t\n" + `{"x":4,"y":5,"z":6}`,
+			JSONOptions{},
+			[]Record{{AccX: 1, AccY: 2, AccZ: 3}, {AccX: 4, AccY: 5, AccZ: 6}},
+		},
+		{
+			"g units",
+			`{"x":0,"y":1,"z":-0.5}` + "\n",
+			JSONOptions{Units: UnitG},
+			[]Record{{AccX: 0, AccY: UnitG.toMS2(), AccZ: -0.5 * UnitG.toMS2()}},
+		},
+		{
+			"skipped bad rows",
+			`{"x":1,"y":2,"z":null}` + "\n" + `{"x":1,"y":2,"z":3}` + "\n" + `{"x":1,"y":"2","z":3}` + "\n" + `{"x":1,"y":20,"z":3}` + "\n",
+			JSONOptions{SkipBadRows: true, MaxAbs: 16},
+			[]Record{{AccX: 1, AccY: 2, AccZ: 3}},
+		},
+		{
+			// The malformed third line is never read
+			"max records",
+			`{"x":1,"y":2,"z":3}` + "\n" + `{"x":4,"y":5,"z":6}` + "\n" + `{"x":` + "\n",
+			JSONOptions{MaxRecords: 2},
+			[]Record{{AccX: 1, AccY: 2, AccZ: 3}, {AccX: 4, AccY: 5, AccZ: 6}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ReadJSONRecords(strings.NewReader(test.input), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(test.want) {
+				t.Fatalf("got %d records, want %d", len(records), len(test.want))
+			}
+			for i, r := range records {
+				if *r != test.want[i] {
+					t.Errorf("record %d: got %+v, want %+v", i, *r, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadJSONRecordsMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		opts    JSONOptions
+		wantErr error
+	}{
+		{"null value", `{"x":1,"y":null,"z":3}`, JSONOptions{}, ErrParse},
+		{"missing key", `{"x":1,"z":3}`, JSONOptions{}, ErrParse},
+		{"missing custom key", `{"x":1,"y":2,"z":3}`, JSONOptions{TempKey: "temp"}, ErrParse},
+		{"string value", `{"x":"1","y":2,"z":3}`, JSONOptions{}, ErrParse},
+		{"not an object", `[1,2,3]`, JSONOptions{}, ErrParse},
+		{"beyond MaxAbs", `{"x":1,"y":-20,"z":3}`, JSONOptions{MaxAbs: 16}, ErrParse},
+		{"all rows skipped", `{"x":1,"y":null,"z":3}` + "\n" + `{"x":1}`, JSONOptions{SkipBadRows: true}, ErrNoData},
+		{"blank lines only", "\n \n\n", JSONOptions{}, ErrNoData},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ReadJSONRecords(strings.NewReader(test.input), test.opts)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+			if records != nil {
+				t.Errorf("got %d records, want none", len(records))
+			}
+		})
+	}
+}
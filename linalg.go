@@ -0,0 +1,138 @@
+package acc
+
+import (
+	"errors"
+	"math"
+)
+
+var errSingular = errors.New("Singular system")
+
+// Pivots smaller than this relative to the largest entry of A are taken as
+// zero by solve
+const singularTolerance = 1e-12
+
+// Solves A x = b by Gaussian elimination with partial pivoting. A and b are
+// modified in place.
+func solve(A [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+
+	scale := 0.0
+	for _, row := range A {
+		for _, v := range row {
+			scale = math.Max(scale, math.Abs(v))
+		}
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(A[row][col]) > math.Abs(A[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(A[pivot][col]) <= singularTolerance*scale {
+			return nil, errSingular
+		}
+		A[col], A[pivot] = A[pivot], A[col]
+		b[col], b[pivot] = b[pivot], b[col]
+
+		for row := col + 1; row < n; row++ {
+			f := A[row][col] / A[col][col]
+			for k := col; k < n; k++ {
+				A[row][k] -= f * A[col][k]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= A[row][k] * x[k]
+		}
+		x[row] = sum / A[row][row]
+	}
+
+	return x, nil
+}
+
+// Returns the coefficients minimizing sum(w_i * (x_i . coef - y_i)^2) by
+// solving the normal equations
+func weightedLeastSquares(xs [][]float64, ys, ws []float64) ([]float64, error) {
+	if len(xs) == 0 {
+		return nil, errSingular
+	}
+	p := len(xs[0])
+
+	A := make([][]float64, p)
+	for i := range A {
+		A[i] = make([]float64, p)
+	}
+	b := make([]float64, p)
+
+	for i, x := range xs {
+		for j := 0; j < p; j++ {
+			b[j] += ws[i] * x[j] * ys[i]
+			for k := 0; k < p; k++ {
+				A[j][k] += ws[i] * x[j] * x[k]
+			}
+		}
+	}
+
+	return solve(A, b)
+}
+
+// Returns the eigenvalues of the symmetric matrix A in no particular order,
+// computed with cyclic Jacobi rotations. A is not modified.
+func symmetricEigenvalues(A [][]float64) []float64 {
+	n := len(A)
+	m := make([][]float64, n)
+	for i := range A {
+		m[i] = append([]float64(nil), A[i]...)
+	}
+
+	for sweep := 0; sweep < 50; sweep++ {
+		off := 0.0
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += m[i][j] * m[i][j]
+			}
+		}
+		if off < 1e-30 {
+			break
+		}
+
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if m[p][q] == 0 {
+					continue
+				}
+
+				// Rotation zeroing m[p][q]
+				theta := (m[q][q] - m[p][p]) / (2 * m[p][q])
+				t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				for k := 0; k < n; k++ {
+					mkp, mkq := m[k][p], m[k][q]
+					m[k][p] = c*mkp - s*mkq
+					m[k][q] = s*mkp + c*mkq
+				}
+				for k := 0; k < n; k++ {
+					mpk, mqk := m[p][k], m[q][k]
+					m[p][k] = c*mpk - s*mqk
+					m[q][k] = s*mpk + c*mqk
+				}
+			}
+		}
+	}
+
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = m[i][i]
+	}
+
+	return values
+}
@@ -0,0 +1,91 @@
+package acc
+
+import "encoding/json"
+
+// Variance of one axis' corrections across the sets merged by
+// MergeCorrections
+type CorrectionSpread struct {
+	Axis rune
+
+	OffsetVariance float64
+	GainVariance   float64
+}
+
+func (s CorrectionSpread) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Axis           string  `json:"axis"`
+		OffsetVariance float64 `json:"offsetVariance"`
+		GainVariance   float64 `json:"gainVariance"`
+	}{string(s.Axis), s.OffsetVariance, s.GainVariance})
+}
+
+// Returns the mean of the correction sets, such as those of several devices
+// of one type, and the population variance of each axis' offset and gain
+// across them. The offsets, gains, matrix rows and temperature slopes are
+// averaged per axis, the offsets at the mean of the sets' reference
+// temperatures. Returns an error unless every set holds one correction
+// for each of X, Y and Z and all sets use the same model.
+func MergeCorrections(sets [][]*Correction) ([]*Correction, []*CorrectionSpread, error) {
+	if len(sets) == 0 {
+		return nil, nil, errorf(ErrInvalidCorrections, "No corrections to merge")
+	}
+
+	byAxis := make([]map[rune]*Correction, 0, len(sets))
+	for i, set := range sets {
+		m := make(map[rune]*Correction, len(axes))
+		for _, c := range set {
+			m[c.Axis] = c
+		}
+		for _, axis := range axes {
+			if m[axis] == nil || len(set) != len(axes) {
+				return nil, nil, errorf(ErrInvalidCorrections, "Correction set %d does not hold exactly one correction for each of X, Y and Z", i)
+			}
+			if m[axis].Matrix != nil && len(m[axis].Matrix) != 3 {
+				return nil, nil, errorf(ErrInvalidCorrections, "Correction set %d: matrix row of axis %c must have 3 elements", i, axis)
+			}
+			if i > 0 && (m[axis].Matrix == nil) != (byAxis[0][axis].Matrix == nil) {
+				return nil, nil, errorf(ErrInvalidCorrections, "Correction set %d uses a different model than set 0", i)
+			}
+		}
+		byAxis = append(byAxis, m)
+	}
+
+	n := float64(len(sets))
+	merged := make([]*Correction, 0, len(axes))
+	spreads := make([]*CorrectionSpread, 0, len(axes))
+	for _, axis := range axes {
+		c := &Correction{Axis: axis}
+		if byAxis[0][axis].Matrix != nil {
+			c.Matrix = make([]float64, 3)
+		}
+		for _, m := range byAxis {
+			c.RefTemp += m[axis].RefTemp / n
+		}
+
+		// Offset of each set at the common reference temperature
+		offsets := make([]float64, 0, len(byAxis))
+		for _, m := range byAxis {
+			offsets = append(offsets, m[axis].Offset+m[axis].TempSlope*(c.RefTemp-m[axis].RefTemp))
+		}
+
+		for i, m := range byAxis {
+			c.Offset += offsets[i] / n
+			c.Gain += m[axis].Gain / n
+			c.TempSlope += m[axis].TempSlope / n
+			for j := range c.Matrix {
+				c.Matrix[j] += m[axis].Matrix[j] / n
+			}
+		}
+
+		s := &CorrectionSpread{Axis: axis}
+		for i, m := range byAxis {
+			s.OffsetVariance += (offsets[i] - c.Offset) * (offsets[i] - c.Offset) / n
+			s.GainVariance += (m[axis].Gain - c.Gain) * (m[axis].Gain - c.Gain) / n
+		}
+
+		merged = append(merged, c)
+		spreads = append(spreads, s)
+	}
+
+	return merged, spreads, nil
+}
@@ -0,0 +1,166 @@
+package acc
+
+import (
+	"errors"
+	"testing"
+)
+
+// Returns a set of X, Y and Z corrections with the given offsets and gains
+func correctionSet(offsets, gains [3]float64) []*Correction {
+	set := make([]*Correction, 0, len(axes))
+	for k, axis := range axes {
+		set = append(set, &Correction{Axis: axis, Offset: offsets[k], Gain: gains[k]})
+	}
+
+	return set
+}
+
+// Returns the set with every correction changed by f
+func withEach(set []*Correction, f func(k int, c *Correction)) []*Correction {
+	for k, c := range set {
+		f(k, c)
+	}
+
+	return set
+}
+
+func TestMergeCorrections(t *testing.T) {
+	tests := []struct {
+		name    string
+		sets    [][]*Correction
+		want    []*Correction
+		spreads []*CorrectionSpread
+	}{
+		{
+			"single set",
+			[][]*Correction{correctionSet([3]float64{0.1, 0.2, 0.3}, [3]float64{1, 0.99, 1.01})},
+			correctionSet([3]float64{0.1, 0.2, 0.3}, [3]float64{1, 0.99, 1.01}),
+			[]*CorrectionSpread{{Axis: 'X'}, {Axis: 'Y'}, {Axis: 'Z'}},
+		},
+		{
+			"mean and variance",
+			[][]*Correction{
+				correctionSet([3]float64{0.1, 0.2, 0.3}, [3]float64{1, 1, 1}),
+				correctionSet([3]float64{0.3, 0.4, 0.3}, [3]float64{1.02, 0.98, 1}),
+			},
+			correctionSet([3]float64{0.2, 0.3, 0.3}, [3]float64{1.01, 0.99, 1}),
+			[]*CorrectionSpread{
+				{Axis: 'X', OffsetVariance: 0.01, GainVariance: 0.0001},
+				{Axis: 'Y', OffsetVariance: 0.01, GainVariance: 0.0001},
+				{Axis: 'Z'},
+			},
+		},
+		{
+			// Both sets have an offset of 0.15 at the mean reference
+			// temperature of 25 degrees
+			"re-referenced offsets",
+			[][]*Correction{
+				withEach(correctionSet([3]float64{0.1, 0.1, 0.1}, [3]float64{1, 1, 1}), func(k int, c *Correction) {
+					c.TempSlope, c.RefTemp = 0.01, 20
+				}),
+				withEach(correctionSet([3]float64{0.3, 0.3, 0.3}, [3]float64{1, 1, 1}), func(k int, c *Correction) {
+					c.TempSlope, c.RefTemp = 0.03, 30
+				}),
+			},
+			withEach(correctionSet([3]float64{0.15, 0.15, 0.15}, [3]float64{1, 1, 1}), func(k int, c *Correction) {
+				c.TempSlope, c.RefTemp = 0.02, 25
+			}),
+			[]*CorrectionSpread{{Axis: 'X'}, {Axis: 'Y'}, {Axis: 'Z'}},
+		},
+		{
+			"full model",
+			[][]*Correction{
+				withEach(correctionSet([3]float64{}, [3]float64{1, 1, 1}), func(k int, c *Correction) {
+					c.Matrix = []float64{0, 0, 0}
+					c.Matrix[k] = 1
+				}),
+				withEach(correctionSet([3]float64{}, [3]float64{1.02, 1.02, 1.02}), func(k int, c *Correction) {
+					c.Matrix = []float64{0.02, 0.02, 0.02}
+					c.Matrix[k] = 1.02
+				}),
+			},
+			withEach(correctionSet([3]float64{}, [3]float64{1.01, 1.01, 1.01}), func(k int, c *Correction) {
+				c.Matrix = []float64{0.01, 0.01, 0.01}
+				c.Matrix[k] = 1.01
+			}),
+			[]*CorrectionSpread{
+				{Axis: 'X', GainVariance: 0.0001},
+				{Axis: 'Y', GainVariance: 0.0001},
+				{Axis: 'Z', GainVariance: 0.0001},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, spreads, err := MergeCorrections(test.sets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(merged) != len(test.want) || len(spreads) != len(test.spreads) {
+				t.Fatalf("got %d corrections and %d spreads, want %d and %d", len(merged), len(spreads), len(test.want), len(test.spreads))
+			}
+
+			for k, c := range merged {
+				want := test.want[k]
+				if c.Axis != want.Axis || !closeTo(c.Offset, want.Offset, 1e-12) || !closeTo(c.Gain, want.Gain, 1e-12) ||
+					!closeTo(c.TempSlope, want.TempSlope, 1e-12) || !closeTo(c.RefTemp, want.RefTemp, 1e-12) {
+					t.Errorf("got correction %+v, want %+v", *c, *want)
+				}
+				if len(c.Matrix) != len(want.Matrix) {
+					t.Fatalf("axis %c: got matrix row %v, want %v", c.Axis, c.Matrix, want.Matrix)
+				}
+				for j := range c.Matrix {
+					if !closeTo(c.Matrix[j], want.Matrix[j], 1e-12) {
+						t.Errorf("axis %c: got matrix row %v, want %v", c.Axis, c.Matrix, want.Matrix)
+						break
+					}
+				}
+			}
+
+			for k, s := range spreads {
+				want := test.spreads[k]
+				if s.Axis != want.Axis || !closeTo(s.OffsetVariance, want.OffsetVariance, 1e-12) || !closeTo(s.GainVariance, want.GainVariance, 1e-12) {
+					t.Errorf("got spread %+v, want %+v", *s, *want)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeCorrectionsInvalid(t *testing.T) {
+	set := func() []*Correction {
+		return correctionSet([3]float64{0.1, 0.2, 0.3}, [3]float64{1, 1, 1})
+	}
+	full := func() []*Correction {
+		return withEach(set(), func(k int, c *Correction) {
+			c.Matrix = []float64{0, 0, 0}
+			c.Matrix[k] = 1
+		})
+	}
+
+	tests := []struct {
+		name string
+		sets [][]*Correction
+	}{
+		{"no sets", nil},
+		{"missing axis", [][]*Correction{set(), set()[:2]}},
+		{"duplicate axis", [][]*Correction{withEach(set(), func(k int, c *Correction) { c.Axis = 'X' })}},
+		{"extra correction", [][]*Correction{append(set(), set()[0])}},
+		{"short matrix row", [][]*Correction{withEach(full(), func(k int, c *Correction) { c.Matrix = c.Matrix[:2] })}},
+		{"mixed models", [][]*Correction{set(), full()}},
+		{"mixed models the other way", [][]*Correction{full(), set()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, spreads, err := MergeCorrections(test.sets)
+			if !errors.Is(err, ErrInvalidCorrections) {
+				t.Errorf("got error %v, want ErrInvalidCorrections", err)
+			}
+			if merged != nil || spreads != nil {
+				t.Error("an invalid merge returned results")
+			}
+		})
+	}
+}
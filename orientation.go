@@ -0,0 +1,109 @@
+package acc
+
+import (
+	"math"
+	"strings"
+)
+
+// Largest angle in degrees between the mean of an epoch and the reading
+// expected in its labelled orientation before the label is suspect
+const labelAngle = 15.0
+
+// Known orientation of the device during a record, given by the axis that
+// points up and so reads +g at rest
+type Orientation uint8
+
+const (
+	// No orientation is known. ICP only fits the norm of such epochs to
+	// gravity.
+	OrientationUnknown Orientation = iota
+	XUp
+	XDown
+	YUp
+	YDown
+	ZUp
+	ZDown
+)
+
+var orientationLabels = map[Orientation]string{
+	XUp:   "+x",
+	XDown: "-x",
+	YUp:   "+y",
+	YDown: "-y",
+	ZUp:   "+z",
+	ZDown: "-z",
+}
+
+// Parses an orientation label: +x, -x, +y, -y, +z or -z, case-insensitive,
+// where a label without a sign points up. An empty label is
+// OrientationUnknown.
+func ParseOrientation(s string) (Orientation, error) {
+	label := strings.ToLower(strings.TrimSpace(s))
+	if label == "" {
+		return OrientationUnknown, nil
+	}
+	if !strings.HasPrefix(label, "+") && !strings.HasPrefix(label, "-") {
+		label = "+" + label
+	}
+
+	for o, l := range orientationLabels {
+		if l == label {
+			return o, nil
+		}
+	}
+
+	return OrientationUnknown, errorf(ErrParse, "invalid orientation %q", s)
+}
+
+func (o Orientation) String() string {
+	if label, ok := orientationLabels[o]; ok {
+		return label
+	}
+
+	return "unknown"
+}
+
+// Returns the reading of a perfect accelerometer at rest in the
+// orientation, or false if the orientation is unknown
+func (o Orientation) target(gravity float64) ([3]float64, bool) {
+	var t [3]float64
+	if o == OrientationUnknown || o > ZDown {
+		return t, false
+	}
+
+	k := int(o-XUp) / 2
+	t[k] = gravity
+	if (o-XUp)%2 == 1 {
+		t[k] = -gravity
+	}
+
+	return t, true
+}
+
+// Returns the orientation shared by all records of the epoch, or
+// OrientationUnknown if they differ
+func (e *Epoch) orientation() Orientation {
+	if len(e.Records) == 0 {
+		return OrientationUnknown
+	}
+
+	o := e.Records[0].Orientation
+	for _, r := range e.Records[1:] {
+		if r.Orientation != o {
+			return OrientationUnknown
+		}
+	}
+
+	return o
+}
+
+// Returns the angle in degrees between u and v, or zero if either is zero
+func angle(u, v [3]float64) float64 {
+	n := euclidean(u) * euclidean(v)
+	if n == 0 {
+		return 0
+	}
+
+	cos := (u[0]*v[0] + u[1]*v[1] + u[2]*v[2]) / n
+	return math.Acos(math.Max(-1, math.Min(1, cos))) * 180 / math.Pi
+}
@@ -0,0 +1,74 @@
+package acc
+
+import (
+	"math"
+	"sort"
+)
+
+// Returns the sample rate in Hz implied by the median interval between the
+// timestamps of consecutive records
+func EstimateSampleRate(records []*Record) (float64, error) {
+	intervals := make([]float64, 0, len(records))
+	for i := 1; i < len(records); i++ {
+		dt := records[i].Time - records[i-1].Time
+		if dt > 0 {
+			intervals = append(intervals, dt)
+		}
+	}
+
+	if len(intervals) == 0 {
+		return 0, errorf(ErrNoData, "No increasing timestamps to estimate the sample rate from")
+	}
+
+	sort.Float64s(intervals)
+	median := intervals[len(intervals)/2]
+	if len(intervals)%2 == 0 {
+		median = (intervals[len(intervals)/2-1] + median) / 2
+	}
+
+	return 1 / median, nil
+}
+
+// Returns the block averages of every n consecutive records, reducing the
+// sample rate by a factor of n. Averaging rather than dropping records also
+// lowers the noise. A trailing block of fewer than n records is discarded.
+// An epoch of the decimated records then holds 1/n as many records for the
+// same EpochSeconds, so RecordsPerSecond must be divided by n as well.
+// Missing values are left out of the averages. A block keeps the
+// orientation shared by all its records, if any.
+func Decimate(records []*Record, n int) []*Record {
+	if n <= 1 {
+		return records
+	}
+
+	decimated := make([]*Record, 0, len(records)/n)
+	for i := 0; i+n <= len(records); i += n {
+		avg := &Record{Orientation: records[i].Orientation}
+		var sums [3]float64
+		var counts [3]int
+		for _, r := range records[i : i+n] {
+			if r.Orientation != avg.Orientation {
+				avg.Orientation = OrientationUnknown
+			}
+			for k, v := range [3]float64{r.AccX, r.AccY, r.AccZ} {
+				if !math.IsNaN(v) {
+					sums[k] += v
+					counts[k]++
+				}
+			}
+			avg.Time += r.Time
+			avg.Temp += r.Temp
+		}
+
+		// An axis missing from the whole block stays missing, as 0/0
+		f := float64(n)
+		avg.AccX = sums[0] / float64(counts[0])
+		avg.AccY = sums[1] / float64(counts[1])
+		avg.AccZ = sums[2] / float64(counts[2])
+		avg.Time /= f
+		avg.Temp /= f
+		decimated = append(decimated, avg)
+	}
+
+	return decimated
+}
@@ -0,0 +1,81 @@
+package acc
+
+import (
+	"math"
+	"testing"
+)
+
+// Returns records with X holding the values, Y twice the values and Z
+// constant, timestamped one second apart
+func seriesRecords(values ...float64) []*Record {
+	records := make([]*Record, 0, len(values))
+	for i, v := range values {
+		records = append(records, &Record{AccX: v, AccY: 2 * v, AccZ: 9.81, Time: float64(i)})
+	}
+
+	return records
+}
+
+// Reports whether the X values of the records are want, Y twice them and Z
+// unchanged
+func matchesSeries(records []*Record, want []float64) bool {
+	if len(records) != len(want) {
+		return false
+	}
+	for i, r := range records {
+		if math.IsNaN(want[i]) {
+			if !math.IsNaN(r.AccX) || !math.IsNaN(r.AccY) {
+				return false
+			}
+			continue
+		}
+		if !closeTo(r.AccX, want[i], 1e-12) || !closeTo(r.AccY, 2*want[i], 1e-12) || !closeTo(r.AccZ, 9.81, 1e-12) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the X values of the records
+func xValues(records []*Record) []float64 {
+	values := make([]float64, 0, len(records))
+	for _, r := range records {
+		values = append(values, r.AccX)
+	}
+
+	return values
+}
+
+func TestDecimate(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name    string
+		records []*Record
+		n       int
+		want    []float64
+		// Mean timestamp of each block
+		times []float64
+	}{
+		{"unchanged", seriesRecords(1, 2, 3), 1, []float64{1, 2, 3}, []float64{0, 1, 2}},
+		{"pairs", seriesRecords(1, 2, 3, 4, 5, 6), 2, []float64{1.5, 3.5, 5.5}, []float64{0.5, 2.5, 4.5}},
+		{"trailing block discarded", seriesRecords(1, 2, 3, 4, 5, 6, 7), 3, []float64{2, 5}, []float64{1, 4}},
+		{"missing value", seriesRecords(1, nan, 3, 4), 2, []float64{1, 3.5}, []float64{0.5, 2.5}},
+		{"missing block", seriesRecords(nan, nan, 3, 5), 2, []float64{nan, 4}, []float64{0.5, 2.5}},
+		{"fewer than n records", seriesRecords(1, 2), 3, []float64{}, []float64{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decimated := Decimate(test.records, test.n)
+			if !matchesSeries(decimated, test.want) {
+				t.Fatalf("got X values %v, want %v", xValues(decimated), test.want)
+			}
+			for i, r := range decimated {
+				if r.Time != test.times[i] {
+					t.Errorf("block %d has timestamp %g, want %g", i, r.Time, test.times[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,608 @@
+package acc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A single accelerometer sample. An axis value is NaN if it is missing from
+// the input, which CSVOptions.AllowMissing permits.
+type Record struct {
+	AccX float64
+	AccY float64
+	AccZ float64
+
+	// Timestamp in seconds, zero unless read from a timestamp column
+	Time float64
+
+	// Temperature of the sensor, zero unless read from a temperature column
+	Temp float64
+
+	// Known orientation of the device, OrientationUnknown unless read from
+	// an orientation column
+	Orientation Orientation
+}
+
+// Returns true if an axis value of the record is missing
+func (r *Record) incomplete() bool {
+	return math.IsNaN(r.AccX) || math.IsNaN(r.AccY) || math.IsNaN(r.AccZ)
+}
+
+// Reports whether r holds exactly the same axis values as o. Missing values
+// never match.
+func (r *Record) sameReading(o *Record) bool {
+	return r.AccX == o.AccX && r.AccY == o.AccY && r.AccZ == o.AccZ
+}
+
+// Controls how ReadCSVRecords interprets its input. Rows need not have the
+// same number of fields: only the axis and timestamp columns are read, and
+// any further columns, such as a status column appended mid-file, are
+// ignored. A row too short to hold those columns is malformed.
+type CSVOptions struct {
+	// Skip the first row
+	Header bool
+
+	// Field separator. Defaults to ',' when zero.
+	Comma rune
+
+	// Lines starting with this rune are ignored. Comments are not
+	// recognised when zero.
+	Comment rune
+
+	// Parse ',' as the decimal point
+	DecimalComma bool
+
+	// Log and skip malformed rows instead of failing
+	SkipBadRows bool
+
+	// Read an empty axis field as a missing value, stored as NaN, instead
+	// of rejecting the row. A row with all three axes empty is still
+	// malformed.
+	AllowMissing bool
+
+	// Largest plausible magnitude of an axis value, in Units. Rows with
+	// larger values are malformed. Not checked when zero.
+	MaxAbs float64
+
+	// Plausible range of an axis value, in Units, such as the range of the
+	// sensor. Rows with a value outside it are malformed, or the value is
+	// set to the nearest bound if Clamp is set. Not checked unless
+	// RangeMin < RangeMax.
+	RangeMin, RangeMax float64
+	Clamp              bool
+
+	// Stop reading once this many records have been parsed. Unlimited
+	// when zero or negative.
+	MaxRecords int
+
+	// 0-based column indices of X, Y and Z. When nil, X, Y and Z are read
+	// from the first three columns other than the timestamp, temperature
+	// and orientation columns.
+	Columns []int
+
+	// Read a timestamp in seconds from column TimeColumn (0-based)
+	ParseTime  bool
+	TimeColumn int
+
+	// Read the sensor temperature from column TempColumn (0-based)
+	ParseTemp  bool
+	TempColumn int
+
+	// Read an orientation label, as accepted by ParseOrientation, from
+	// column OrientationColumn (0-based)
+	ParseOrientation  bool
+	OrientationColumn int
+
+	// Unit of the axis values, which are converted to m/s². Defaults to
+	// UnitMS2 when empty.
+	Units Unit
+}
+
+// Returns the 0-based column indices of X, Y and Z, and of the timestamp,
+// temperature and orientation or -1 if there are none
+func (opts CSVOptions) columns() ([3]int, int, int, int) {
+	timeColumn, tempColumn, orientationColumn := -1, -1, -1
+	if opts.ParseTime {
+		timeColumn = opts.TimeColumn
+	}
+	if opts.ParseTemp {
+		tempColumn = opts.TempColumn
+	}
+	if opts.ParseOrientation {
+		orientationColumn = opts.OrientationColumn
+	}
+
+	var axes [3]int
+	if len(opts.Columns) == 3 {
+		copy(axes[:], opts.Columns)
+		return axes, timeColumn, tempColumn, orientationColumn
+	}
+
+	col := 0
+	for i := range axes {
+		for col == timeColumn || col == tempColumn || col == orientationColumn {
+			col++
+		}
+		axes[i] = col
+		col++
+	}
+
+	return axes, timeColumn, tempColumn, orientationColumn
+}
+
+// Parses a field, ignoring surrounding whitespace
+func parseFloat(field string, opts CSVOptions) (float64, error) {
+	field = strings.TrimSpace(field)
+	if opts.DecimalComma {
+		field = strings.Replace(field, ",", ".", 1)
+	}
+
+	return strconv.ParseFloat(field, 64)
+}
+
+// Returns true if every field of the row is empty or whitespace
+func blankRow(row []string) bool {
+	for _, field := range row {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns true if the axis, timestamp and temperature fields of the row
+// parse as floats. Empty axis fields count as numeric with
+// CSVOptions.AllowMissing.
+func isNumericRow(row []string, opts CSVOptions) bool {
+	axes, timeColumn, tempColumn, _ := opts.columns()
+	cols := append(axes[:], timeColumn, tempColumn)
+
+	for j, i := range cols {
+		if i < 0 || i >= len(row) {
+			continue
+		}
+		if j < len(axes) && opts.AllowMissing && strings.TrimSpace(row[i]) == "" {
+			continue
+		}
+		if _, err := parseFloat(row[i], opts); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reads the records of the CSV file at filePath, or of stdin when filePath
+// is empty or "-"
+func ReadCSVFile(filePath string, opts CSVOptions) ([]*Record, error) {
+	return readFile(filePath, func(in io.Reader) ([]*Record, error) {
+		return ReadCSVRecords(in, opts)
+	})
+}
+
+// Parses the file at filePath, or stdin when filePath is empty or "-", with
+// read
+func readFile(filePath string, read func(io.Reader) ([]*Record, error)) ([]*Record, error) {
+	if filePath == "" || filePath == "-" {
+		records, err := read(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse stdin: %w", err)
+		}
+		return records, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read input file at path %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	in := &countingReader{r: f}
+	records, err := read(in)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse file at path %s: %w", filePath, err)
+	}
+
+	// Reading stops early when the number of records is capped
+	if info, err := f.Stat(); err == nil && in.n < info.Size() {
+		log.Infof("Read %d records from the first %d of %d bytes of %s", len(records), in.n, info.Size(), filePath)
+	}
+
+	return records, nil
+}
+
+// Counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Returns a reader yielding the decompressed contents of r if it starts with
+// the gzip magic number, and the contents of r unchanged otherwise
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(2)
+	if err != nil || !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return br, nil
+	}
+
+	return gzip.NewReader(br)
+}
+
+// Returns a reader yielding the contents of r without a leading UTF-8 byte
+// order mark, which tools on Windows often write and which would otherwise
+// be read as part of the first value
+func skipBOM(r io.Reader) io.Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	if bom, err := br.Peek(3); err == nil && bytes.Equal(bom, []byte{0xef, 0xbb, 0xbf}) {
+		br.Discard(3)
+	}
+
+	return br
+}
+
+// Streams records from a CSV input one row at a time
+type CSVRecordReader struct {
+	csv  *csv.Reader
+	opts CSVOptions
+
+	// true until the first row has been read
+	first bool
+
+	skipped int
+
+	// Number of values clamped to opts.RangeMin or opts.RangeMax
+	clamped int
+
+	// Lines of the first and last skipped rows
+	firstSkipped, lastSkipped int
+
+	// Number of fields of the first record, zero until it has been read
+	width int
+}
+
+// Returns a reader of the records in the CSV stream. The stream may be
+// gzip-compressed.
+func NewCSVRecordReader(in io.Reader, opts CSVOptions) (*CSVRecordReader, error) {
+	if opts.Columns != nil {
+		if len(opts.Columns) != 3 {
+			return nil, errorf(ErrInvalidConfig, "Expected 3 axis columns, got %d", len(opts.Columns))
+		}
+		for _, col := range opts.Columns {
+			if col < 0 {
+				return nil, errorf(ErrInvalidConfig, "Invalid axis column %d", col)
+			}
+		}
+	}
+
+	in, err := maybeGunzip(in)
+	if err != nil {
+		return nil, errorf(ErrParse, "Invalid gzip stream: %w", err)
+	}
+
+	csvReader := csv.NewReader(skipBOM(in))
+	if opts.Comma != 0 {
+		csvReader.Comma = opts.Comma
+	}
+	if opts.Comment != 0 && opts.Comment == csvReader.Comma {
+		return nil, errorf(ErrInvalidConfig, "The comment character %q is also the delimiter", opts.Comment)
+	}
+	csvReader.Comment = opts.Comment
+	// Allow rows of varying width. Short rows are reported by parseRecord
+	// with their line number and extra columns are ignored.
+	csvReader.FieldsPerRecord = -1
+	csvReader.ReuseRecord = true
+	csvReader.TrimLeadingSpace = true
+
+	return &CSVRecordReader{
+		csv:   csvReader,
+		opts:  opts,
+		first: true,
+	}, nil
+}
+
+// Returns the next record. X, Y and Z are read from opts.Columns, or from
+// the first three columns other than the timestamp and temperature columns.
+// Rows whose fields are all blank, such as trailing empty rows, are
+// skipped. Returns io.EOF when the input is exhausted.
+func (r *CSVRecordReader) Read() (*Record, error) {
+	for {
+		row, err := r.csv.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			// The CSV reader resumes after the line holding a syntax error,
+			// such as a stray quote or a row cut off by a truncated write
+			var perr *csv.ParseError
+			if !r.opts.SkipBadRows || !errors.As(err, &perr) {
+				return nil, errorf(ErrParse, "Invalid CSV: %w", err)
+			}
+
+			log.Warnf("Skipping line %d: %s", perr.StartLine, perr.Err.Error())
+			r.skip(perr.StartLine)
+			continue
+		}
+		line, _ := r.csv.FieldPos(0)
+
+		if blankRow(row) {
+			continue
+		}
+
+		if r.first {
+			r.first = false
+			if r.opts.Header {
+				continue
+			}
+			if !isNumericRow(row, r.opts) {
+				log.Warnln("First row is not numeric, skipping it as a header. Use -header to silence this warning.")
+				continue
+			}
+		}
+
+		rec, clamped, err := parseRecord(row, r.opts)
+		if err != nil {
+			if !r.opts.SkipBadRows {
+				return nil, errorf(ErrParse, "Line %d: %w", line, err)
+			}
+
+			log.Warnf("Skipping line %d: %s", line, err.Error())
+			r.skip(line)
+			continue
+		}
+
+		if r.width == 0 {
+			r.width = len(row)
+		}
+		r.clamped += clamped
+
+		return rec, nil
+	}
+}
+
+// Returns the number of fields in the row of the first record read, or zero
+// if no record has been read yet
+func (r *CSVRecordReader) Width() int {
+	return r.width
+}
+
+// Counts the malformed row at line as skipped
+func (r *CSVRecordReader) skip(line int) {
+	if r.skipped == 0 {
+		r.firstSkipped = line
+	}
+	r.lastSkipped = line
+	r.skipped++
+}
+
+// Returns the number of malformed rows skipped so far
+func (r *CSVRecordReader) Skipped() int {
+	return r.skipped
+}
+
+// Returns the number of values clamped to the plausible range so far
+func (r *CSVRecordReader) Clamped() int {
+	return r.clamped
+}
+
+// Returns the lines of the first and last malformed rows skipped so far, or
+// zeros if none were skipped
+func (r *CSVRecordReader) SkippedLines() (int, int) {
+	return r.firstSkipped, r.lastSkipped
+}
+
+// Reads all records from the CSV stream. The stream may be gzip-compressed.
+// Returns an error if it holds no data rows.
+func ReadCSVRecords(in io.Reader, opts CSVOptions) ([]*Record, error) {
+	records := make([]*Record, 0)
+
+	reader, err := NewCSVRecordReader(in, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for opts.MaxRecords <= 0 || len(records) < opts.MaxRecords {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, rec)
+	}
+
+	if reader.Clamped() > 0 {
+		log.Warnf("Clamped %d values outside [%g, %g]", reader.Clamped(), opts.RangeMin, opts.RangeMax)
+	}
+
+	if reader.Skipped() > 0 {
+		first, last := reader.SkippedLines()
+		log.Warnf("Skipped %d malformed rows between lines %d and %d, keeping %d records", reader.Skipped(), first, last, len(records))
+	}
+
+	if err := checkNotEmpty(records, reader.Skipped()); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Writes the records as X,Y,Z rows to w, the layout ReadCSVRecords reads by
+// default, with precision decimal places or, if precision is negative, the
+// fewest digits that read back to the same values. The values are written
+// as they are, without converting units, and missing values as empty fields.
+func WriteCSVRecords(w io.Writer, records []*Record, precision int) error {
+	cw := csv.NewWriter(w)
+	for _, r := range records {
+		row := make([]string, 0, 3)
+		for _, v := range [3]float64{r.AccX, r.AccY, r.AccZ} {
+			field := ""
+			if !math.IsNaN(v) {
+				field = strconv.FormatFloat(v, 'f', precision, 64)
+			}
+			row = append(row, field)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func parseRecord(r []string, opts CSVOptions) (*Record, int, error) {
+	axes, timeColumn, tempColumn, orientationColumn := opts.columns()
+
+	width := timeColumn + 1
+	for _, col := range append(axes[:], tempColumn, orientationColumn) {
+		if col >= width {
+			width = col + 1
+		}
+	}
+	if len(r) < width {
+		return nil, 0, fmt.Errorf("expected at least %d fields, got %d", width, len(r))
+	}
+
+	// Missing values are checked as zero and stored as NaN
+	var values, checked [3]float64
+	missing := 0
+	for k, col := range axes {
+		if opts.AllowMissing && strings.TrimSpace(r[col]) == "" {
+			values[k] = math.NaN()
+			missing++
+			continue
+		}
+
+		v, err := parseField(r, col, [3]string{"X", "Y", "Z"}[k], opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		values[k], checked[k] = v, v
+	}
+	if missing == len(axes) {
+		return nil, 0, errors.New("all axis columns are empty")
+	}
+
+	if err := checkAxes(checked, opts.MaxAbs); err != nil {
+		return nil, 0, err
+	}
+
+	clamped := 0
+	if opts.RangeMin < opts.RangeMax {
+		for k, v := range values {
+			if math.IsNaN(v) || (v >= opts.RangeMin && v <= opts.RangeMax) {
+				continue
+			}
+			if !opts.Clamp {
+				return nil, 0, fmt.Errorf("%s value %g is outside the plausible range [%g, %g]", [3]string{"X", "Y", "Z"}[k], v, opts.RangeMin, opts.RangeMax)
+			}
+			values[k] = math.Max(opts.RangeMin, math.Min(opts.RangeMax, v))
+			clamped++
+		}
+	}
+
+	f := opts.Units.toMS2()
+	rec := &Record{
+		AccX: values[0] * f,
+		AccY: values[1] * f,
+		AccZ: values[2] * f,
+	}
+
+	var err error
+
+	if timeColumn >= 0 {
+		rec.Time, err = parseField(r, timeColumn, "time", opts)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if tempColumn >= 0 {
+		rec.Temp, err = parseField(r, tempColumn, "temperature", opts)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if orientationColumn >= 0 {
+		rec.Orientation, err = ParseOrientation(r[orientationColumn])
+		if err != nil {
+			return nil, 0, fmt.Errorf("column %d (orientation): %s", orientationColumn, err.Error())
+		}
+	}
+
+	return rec, clamped, nil
+}
+
+// Parses column col of the row, which holds the named value. Errors name the
+// column and the offending field.
+func parseField(r []string, col int, name string, opts CSVOptions) (float64, error) {
+	if strings.TrimSpace(r[col]) == "" {
+		return 0, fmt.Errorf("column %d (%s) is empty", col, name)
+	}
+
+	v, err := parseFloat(r[col], opts)
+	if err != nil {
+		return 0, fmt.Errorf("column %d (%s): invalid number %q", col, name, r[col])
+	}
+
+	return v, nil
+}
+
+// Returns an error if an axis value is NaN or infinite or, when maxAbs is
+// positive, larger than maxAbs in magnitude. Such values would otherwise
+// propagate silently into the epoch statistics and ICP.
+func checkAxes(values [3]float64, maxAbs float64) error {
+	for i, v := range values {
+		switch {
+		case math.IsNaN(v):
+			return fmt.Errorf("%c is NaN", axes[i])
+		case math.IsInf(v, 0):
+			return fmt.Errorf("%c is infinite", axes[i])
+		case maxAbs > 0 && math.Abs(v) > maxAbs:
+			return fmt.Errorf("%c value %g exceeds the plausible magnitude %g", axes[i], v, maxAbs)
+		}
+	}
+
+	return nil
+}
+
+// Returns an error naming the cause if no records were read, either because
+// the input held no data rows or because all of them were skipped
+func checkNotEmpty(records []*Record, skipped int) error {
+	if len(records) > 0 {
+		return nil
+	}
+
+	if skipped > 0 {
+		return errorf(ErrNoData, "All %d data rows are malformed", skipped)
+	}
+
+	return errorf(ErrNoData, "The input contains no data rows")
+}
@@ -0,0 +1,286 @@
+package acc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVRecordsNoData(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  CSVOptions
+	}{
+		{"zero bytes", "", CSVOptions{}},
+		{"header only", "accX,accY,accZ\n", CSVOptions{Header: true}},
+		{"detected header only", "accX (m/s2),accY (m/s2),accZ (m/s2)\n", CSVOptions{}},
+		{"header without newline", "x,y,z", CSVOptions{Header: true}},
+		{"blank lines", "\n\n\n", CSVOptions{}},
+		{"comments only", "# logger v2\n# no data\n", CSVOptions{Comment: '#'}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ReadCSVRecords(strings.NewReader(test.input), test.opts)
+			if !errors.Is(err, ErrNoData) {
+				t.Errorf("got error %v, want ErrNoData", err)
+			}
+			if records != nil {
+				t.Errorf("got %d records, want none", len(records))
+			}
+		})
+	}
+}
+
+func TestReadCSVFileEmpty(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"empty.csv":  "",
+		"header.csv": "x,y,z\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := ReadCSVFile(path, CSVOptions{Header: true})
+		if !errors.Is(err, ErrNoData) {
+			t.Errorf("%s: got error %v, want ErrNoData", name, err)
+		}
+	}
+}
+
+func TestReadCSVRecordsPaddedFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  CSVOptions
+	}{
+		{"leading spaces", "  0.12,  9.80,  0.01\n", CSVOptions{}},
+		{"trailing spaces", "0.12  ,9.80 ,0.01 \n", CSVOptions{}},
+		{"tabs", "\t0.12,\t9.80\t,0.01\t\n", CSVOptions{}},
+		{"semicolons and decimal commas", " 0,12 ; 9,80 ; 0,01 \n", CSVOptions{Comma: ';', DecimalComma: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ReadCSVRecords(strings.NewReader(test.input), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			r := records[0]
+			if r.AccX != 0.12 || r.AccY != 9.80 || r.AccZ != 0.01 {
+				t.Errorf("got (%g, %g, %g), want (0.12, 9.8, 0.01)", r.AccX, r.AccY, r.AccZ)
+			}
+		})
+	}
+}
+
+func TestReadCSVRecordsEmptyFields(t *testing.T) {
+	input := "0.1,9.8,0.2\n0.1,  ,0.2\n"
+
+	if _, err := ReadCSVRecords(strings.NewReader(input), CSVOptions{}); !errors.Is(err, ErrParse) {
+		t.Errorf("got error %v, want ErrParse for an empty field", err)
+	}
+
+	records, err := ReadCSVRecords(strings.NewReader(input), CSVOptions{SkipBadRows: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("got %d records with -skip-bad-rows, want 1", len(records))
+	}
+
+	records, err = ReadCSVRecords(strings.NewReader(input), CSVOptions{AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !math.IsNaN(records[1].AccY) {
+		t.Errorf("empty field was not read as a missing value")
+	}
+}
+
+func TestReadCSVRecordsPoisonedRows(t *testing.T) {
+	good := "0.1,9.8,0.2\n"
+	tests := []struct {
+		name string
+		row  string
+	}{
+		{"NaN", "NaN,9.8,0.2\n"},
+		{"infinity", "0.1,+Inf,0.2\n"},
+		{"negative infinity", "0.1,9.8,-Inf\n"},
+		{"implausible value", "0.1,9.8,1e300\n"},
+		{"overflow", "0.1,1e999,0.2\n"},
+	}
+
+	opts := CSVOptions{MaxAbs: 1000}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := good + test.row + good
+			if _, err := ReadCSVRecords(strings.NewReader(input), opts); !errors.Is(err, ErrParse) {
+				t.Errorf("got error %v, want ErrParse", err)
+			}
+
+			skipping := opts
+			skipping.SkipBadRows = true
+			reader, err := NewCSVRecordReader(strings.NewReader(input), skipping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for {
+				r, err := reader.Read()
+				if err != nil {
+					break
+				}
+				if math.IsNaN(r.AccX+r.AccY+r.AccZ) || math.IsInf(r.AccX+r.AccY+r.AccZ, 0) {
+					t.Errorf("read a poisoned record %v", *r)
+				}
+				n++
+			}
+			if n != 2 || reader.Skipped() != 1 {
+				t.Errorf("read %d records and skipped %d rows, want 2 and 1", n, reader.Skipped())
+			}
+			if first, last := reader.SkippedLines(); first != 2 || last != 2 {
+				t.Errorf("skipped lines %d to %d, want line 2", first, last)
+			}
+		})
+	}
+
+	t.Run("all rows poisoned", func(t *testing.T) {
+		input := "NaN,NaN,NaN\nInf,0,0\n"
+		_, err := ReadCSVRecords(strings.NewReader(input), CSVOptions{SkipBadRows: true})
+		if !errors.Is(err, ErrNoData) {
+			t.Errorf("got error %v, want ErrNoData", err)
+		}
+	})
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	records := noisyRecords(rng, 1000, [3]float64{0.1, -0.2, 9.81}, 0.5)
+	records = append(records, &Record{AccX: 1e-300, AccY: -0, AccZ: 123456789.123456789})
+	records = append(records, &Record{AccX: math.NaN(), AccY: 9.81, AccZ: 0})
+
+	var buf bytes.Buffer
+	if err := WriteCSVRecords(&buf, records, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadCSVRecords(&buf, CSVOptions{AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(records) {
+		t.Fatalf("read %d records back, want %d", len(read), len(records))
+	}
+	same := func(a, b float64) bool {
+		return a == b || (math.IsNaN(a) && math.IsNaN(b))
+	}
+	for i, r := range read {
+		w := records[i]
+		if !same(r.AccX, w.AccX) || !same(r.AccY, w.AccY) || !same(r.AccZ, w.AccZ) {
+			t.Errorf("record %d: read (%g, %g, %g), wrote (%g, %g, %g)", i, r.AccX, r.AccY, r.AccZ, w.AccX, w.AccY, w.AccZ)
+		}
+	}
+
+	// Values with at most as many decimals as written are reproduced
+	input := "0.123,-9.810,0.004\n-0.500,0.000,9.999\n"
+	read, err = ReadCSVRecords(strings.NewReader(input), CSVOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := WriteCSVRecords(&buf, read, 3); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != input {
+		t.Errorf("wrote %q, want %q", buf.String(), input)
+	}
+}
+
+func TestReadCSVRecordsBOM(t *testing.T) {
+	const bom = "\ufeff"
+
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte(bom + "0.1,9.8,0.2\n"))
+	gw.Close()
+
+	tests := []struct {
+		name  string
+		input string
+		opts  CSVOptions
+	}{
+		{"data", bom + "0.1,9.8,0.2\n", CSVOptions{}},
+		{"header", bom + "x,y,z\n0.1,9.8,0.2\n", CSVOptions{Header: true}},
+		{"detected header", bom + "accX,accY,accZ\n0.1,9.8,0.2\n", CSVOptions{}},
+		{"semicolons", bom + "0.1;9.8;0.2\n", CSVOptions{Comma: ';'}},
+		{"gzip", gzipped.String(), CSVOptions{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ReadCSVRecords(strings.NewReader(test.input), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			if r := records[0]; r.AccX != 0.1 || r.AccY != 9.8 || r.AccZ != 0.2 {
+				t.Errorf("got (%g, %g, %g), want (0.1, 9.8, 0.2)", r.AccX, r.AccY, r.AccZ)
+			}
+		})
+	}
+
+	if _, err := ReadCSVRecords(strings.NewReader(bom), CSVOptions{}); !errors.Is(err, ErrNoData) {
+		t.Errorf("got error %v for a lone BOM, want ErrNoData", err)
+	}
+}
+
+func TestReadCSVRecordsBlankLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"two trailing blank lines", "0.1,9.8,0.2\n0.2,9.7,0.1\n\n\n"},
+		{"trailing blank lines without newline", "0.1,9.8,0.2\n0.2,9.7,0.1\n\n"},
+		{"CRLF blank lines", "0.1,9.8,0.2\r\n0.2,9.7,0.1\r\n\r\n\r\n"},
+		{"blank line within", "0.1,9.8,0.2\n\n0.2,9.7,0.1\n"},
+		{"empty fields", "0.1,9.8,0.2\n,,\n0.2,9.7,0.1\n ,\t, \n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ReadCSVRecords(strings.NewReader(test.input), CSVOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 2 {
+				t.Fatalf("got %d records, want 2", len(records))
+			}
+			if records[1].AccX != 0.2 {
+				t.Errorf("second record has X %g, want 0.2", records[1].AccX)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "trailing.csv")
+	if err := os.WriteFile(path, []byte(tests[0].input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := ReadCSVFile(path, CSVOptions{}); err != nil || len(records) != 2 {
+		t.Errorf("read %d records with error %v from a file ending in two blank lines, want 2", len(records), err)
+	}
+}
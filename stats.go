@@ -0,0 +1,92 @@
+package acc
+
+import "context"
+
+// Statistics of a single epoch
+type EpochSummary struct {
+	// Position of the epoch in the summarized slice
+	Index int
+
+	// Number of records in the epoch
+	Records int
+
+	MeanX, MeanY, MeanZ float64
+	SDX, SDY, SDZ       float64
+
+	// Euclidean norm of the mean vector
+	Norm float64
+
+	// Mean of the Euclidean norms of the records
+	MeanNorm float64
+}
+
+// Returns the statistics of every non-empty epoch, computed with the SD
+// divisor, outlier trimming and number of workers in cfg
+func SummarizeEpochs(epochs []*Epoch, cfg Config) []*EpochSummary {
+	// The background context is never cancelled
+	stats, _ := computeEpochStats(context.Background(), epochs, cfg)
+
+	summaries := make([]*EpochSummary, 0, len(epochs))
+	for i, e := range epochs {
+		if len(e.Records) == 0 {
+			continue
+		}
+
+		s := stats[i]
+		if s.trimmed != nil {
+			e = s.trimmed
+		}
+
+		summaries = append(summaries, &EpochSummary{
+			Index:    i,
+			Records:  len(e.Records),
+			MeanX:    s.meanX,
+			MeanY:    s.meanY,
+			MeanZ:    s.meanZ,
+			SDX:      s.sdX,
+			SDY:      s.sdY,
+			SDZ:      s.sdZ,
+			Norm:     euclidean([3]float64{s.meanX, s.meanY, s.meanZ}),
+			MeanNorm: e.meanNorm(),
+		})
+	}
+
+	return summaries
+}
+
+// Returns a simple per-axis offset estimate to compare ICP against: the mean
+// over the non-empty epochs of the offset that moves each mean vector onto
+// the sphere of radius gravity along its own direction, assuming unit gains.
+// If every epoch has the Z axis up, this is [0, 0, gravity] minus the mean
+// vector. Returns zeros if there are no non-empty epochs.
+func NaiveOffsets(epochs []*Epoch, gravity float64) [3]float64 {
+	var offsets [3]float64
+	n := 0
+	for _, e := range epochs {
+		if len(e.Records) == 0 {
+			continue
+		}
+
+		x, y, z := e.Mean()
+		m := [3]float64{x, y, z}
+		norm := euclidean(m)
+		if norm == 0 {
+			continue
+		}
+
+		for k := range m {
+			offsets[k] += m[k]/norm*gravity - m[k]
+		}
+		n++
+	}
+
+	if n == 0 {
+		return offsets
+	}
+
+	for k := range offsets {
+		offsets[k] /= float64(n)
+	}
+
+	return offsets
+}
@@ -0,0 +1,175 @@
+package acc
+
+import (
+	"context"
+	"strings"
+)
+
+// Fits corrections to records as they arrive, for pipelines that cannot
+// hold all records. Records are split into consecutive epochs of
+// cfg.EpochSize() records as in GetEpochs, and only the mean of each
+// stationary epoch is kept, so memory is bounded by one open epoch plus one
+// record per retained epoch, or per maxEpochs epochs if set. Epochs never
+// overlap, so cfg.Stride is ignored, and cfg.Orientations and
+// cfg.OverlapWeighting do not apply. As each epoch is reduced to its mean,
+// NormOfMean weighting is used whatever cfg.WeightNorm holds. An epoch is
+// only tested once complete, so a trailing partial epoch is dropped
+// whatever cfg.DropPartial holds. Gaps between records are not detected,
+// so cfg.MaxGap must be zero. Refits log their outcome and warnings at
+// debug level only; the Diagnostics returned by Corrections report them.
+type StreamCalibrator struct {
+	cfg        Config
+	refitEvery int
+	maxEpochs  int
+
+	// Records of the epoch being filled
+	current []*Record
+
+	// Retained stationary epochs, each reduced to one record holding its
+	// mean, oldest first
+	epochs []*Epoch
+
+	// Number of epochs retained since the last fit
+	pending int
+
+	corrections []*Correction
+	diag        *Diagnostics
+}
+
+// Returns a calibrator that refits once refitEvery new stationary epochs
+// have been retained, and that keeps at most maxEpochs of them, discarding
+// the oldest first. refitEvery defaults to 1 and maxEpochs is unlimited
+// when zero.
+func NewStreamCalibrator(cfg Config, refitEvery, maxEpochs int) (*StreamCalibrator, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if refitEvery < 0 || maxEpochs < 0 {
+		return nil, errorf(ErrInvalidConfig, "The refit interval and the number of epochs must not be negative")
+	}
+	if cfg.MaxGap > 0 {
+		return nil, errorf(ErrInvalidConfig, "Streamed records are not split at time gaps, so the largest gap must be zero")
+	}
+	if refitEvery == 0 {
+		refitEvery = 1
+	}
+
+	return &StreamCalibrator{
+		cfg:        cfg,
+		refitEvery: refitEvery,
+		maxEpochs:  maxEpochs,
+		current:    make([]*Record, 0, cfg.EpochSize()),
+	}, nil
+}
+
+// Adds the next record, which is in m/s². When it completes an epoch, the
+// epoch is tested as in PreProcessEpochs and the corrections are refitted
+// if enough stationary epochs have been retained since the last fit.
+// Returns the error of a failed refit, after which the record is still
+// added and the previous corrections are kept.
+func (s *StreamCalibrator) Add(r *Record) error {
+	s.current = append(s.current, r)
+	if len(s.current) < s.cfg.EpochSize() {
+		return nil
+	}
+
+	e := &Epoch{Records: s.current[s.cfg.Settle:]}
+	s.current = make([]*Record, 0, s.cfg.EpochSize())
+
+	summary, ok := s.stationary(e)
+	if !ok {
+		return nil
+	}
+
+	s.epochs = append(s.epochs, summary)
+	if s.maxEpochs > 0 && len(s.epochs) > s.maxEpochs {
+		s.epochs = s.epochs[len(s.epochs)-s.maxEpochs:]
+	}
+
+	s.pending++
+	if s.pending < s.refitEvery {
+		return nil
+	}
+
+	return s.fit()
+}
+
+// Returns the latest corrections and their diagnostics, fitting them first
+// if stationary epochs have been retained since the last fit. Returns an
+// error if no stationary epoch has been retained yet.
+func (s *StreamCalibrator) Corrections() ([]*Correction, *Diagnostics, error) {
+	if s.pending > 0 || s.corrections == nil {
+		if err := s.fit(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return s.corrections, s.diag, nil
+}
+
+// Returns the number of stationary epochs currently retained
+func (s *StreamCalibrator) Epochs() int {
+	return len(s.epochs)
+}
+
+// Fits the corrections to the retained epochs. Under cfg.Strict, corrections
+// outside the plausible bounds fail the fit; otherwise the diagnostics list
+// them.
+func (s *StreamCalibrator) fit() error {
+	if len(s.epochs) == 0 {
+		return errorf(ErrNoEpochs, "No stationary epochs retained yet")
+	}
+
+	cfg := s.cfg
+	cfg.WeightNorm = NormOfMean
+	cfg.OverlapWeighting = false
+
+	// The background context is never cancelled
+	corrections, diag, err := icp(context.Background(), s.epochs, cfg, true)
+	if err != nil {
+		return err
+	}
+
+	if problems := cfg.implausible(corrections); len(problems) > 0 {
+		if cfg.Strict {
+			return errorf(ErrImplausible, "Implausible corrections: %s", strings.Join(problems, "; "))
+		}
+		diag.Implausible = problems
+	}
+
+	s.corrections, s.diag = corrections, diag
+	s.pending = 0
+	return nil
+}
+
+// Returns the epoch reduced to one record holding its mean, temperature and
+// orientation, and whether it is stationary
+func (s *StreamCalibrator) stationary(e *Epoch) (*Epoch, bool) {
+	if s.cfg.StuckRun > 0 && s.cfg.DropStuck && e.stuckRuns(s.cfg.StuckRun) > 0 {
+		return nil, false
+	}
+
+	cfg := s.cfg
+	cfg.Workers = 1
+
+	// The background context is never cancelled
+	stats, _ := computeEpochStats(context.Background(), []*Epoch{e}, cfg)
+	st := stats[0]
+	if st.trimmed != nil {
+		e = st.trimmed
+	}
+	if !belowThresholds(st.spread(cfg.Robust), cfg.Thresholds) {
+		return nil, false
+	}
+
+	return &Epoch{
+		Records: []*Record{{
+			AccX: st.meanX,
+			AccY: st.meanY,
+			AccZ: st.meanZ,
+			Temp: e.meanTemp(),
+
+			Orientation: e.orientation(),
+		}},
+	}, true
+}
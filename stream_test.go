@@ -0,0 +1,125 @@
+package acc
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestNewStreamCalibratorMaxGap(t *testing.T) {
+	cfg := testConfig(50, 0.05)
+	cfg.MaxGap = 1
+	if _, err := NewStreamCalibrator(cfg, 0, 0); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("got error %v, want ErrInvalidConfig", err)
+	}
+}
+
+func TestStreamCalibrator(t *testing.T) {
+	d, a := [3]float64{0.3, -0.2, 0.15}, [3]float64{1.05, 0.97, 1.02}
+	epochs := orientationEpochs(rand.New(rand.NewSource(1)), 40, 50, d, a, 0.01)
+
+	s, err := NewStreamCalibrator(testConfig(50, 0.05), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range epochs {
+		for _, r := range e.Records {
+			if err := s.Add(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// A partial epoch is never tested
+	for _, r := range epochs[0].Records[:10] {
+		if err := s.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.Epochs() != len(epochs) {
+		t.Errorf("retained %d epochs, want %d", s.Epochs(), len(epochs))
+	}
+	corrections, _, err := s.Corrections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, c := range corrections {
+		if math.Abs(c.Offset-d[k]) > 2e-3 || math.Abs(c.Gain-a[k]) > 2e-3 {
+			t.Errorf("%c: got offset %g and gain %g, want %g and %g", c.Axis, c.Offset, c.Gain, d[k], a[k])
+		}
+	}
+}
+
+func TestStreamCalibratorImplausible(t *testing.T) {
+	a := [3]float64{1, 1, 1}
+	plausible, implausible := [3]float64{0.1, -0.1, 0.05}, [3]float64{0.5, -0.1, 0.05}
+
+	tests := []struct {
+		name   string
+		strict bool
+	}{
+		{"strict", true},
+		{"lenient", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(50, 0.05)
+			cfg.MaxOffset = 0.3
+			cfg.Strict = test.strict
+
+			// Only the epochs of the implausible sensor are retained for
+			// the second fit
+			s, err := NewStreamCalibrator(cfg, 20, 20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			add := func(d [3]float64) error {
+				var err error
+				for _, e := range orientationEpochs(rand.New(rand.NewSource(1)), 20, 50, d, a, 0.01) {
+					for _, r := range e.Records {
+						err = s.Add(r)
+					}
+				}
+				return err
+			}
+
+			if err := add(plausible); err != nil {
+				t.Fatal(err)
+			}
+			previous, diag, err := s.Corrections()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diag.Implausible) != 0 {
+				t.Fatalf("plausible corrections reported as %v", diag.Implausible)
+			}
+
+			err = add(implausible)
+			if test.strict {
+				if !errors.Is(err, ErrImplausible) {
+					t.Fatalf("got error %v, want ErrImplausible", err)
+				}
+				if s.corrections[0] != previous[0] {
+					t.Error("the implausible corrections replaced the previous ones")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			corrections, diag, err := s.Corrections()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(corrections[0].Offset-implausible[0]) > 2e-3 {
+				t.Errorf("got offset %g, want %g", corrections[0].Offset, implausible[0])
+			}
+			if len(diag.Implausible) != 1 {
+				t.Errorf("got problems %v, want the offset of X", diag.Implausible)
+			}
+		})
+	}
+}
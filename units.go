@@ -0,0 +1,58 @@
+package acc
+
+// Unit of the acceleration values in an input
+type Unit string
+
+const (
+	// Metres per second squared, around 9.81 when stationary
+	UnitMS2 Unit = "ms2"
+
+	// Multiples of DefaultGravity, around 1 when stationary
+	UnitG Unit = "g"
+)
+
+// Returns the factor converting values in unit u to m/s²
+func (u Unit) toMS2() float64 {
+	if u == UnitG {
+		return DefaultGravity
+	}
+
+	return 1
+}
+
+// Returns v, given in unit u, in m/s²
+func ConvertToMS2(v float64, u Unit) float64 {
+	return v * u.toMS2()
+}
+
+// Returns copies of the corrections, which are in m/s², with offsets and
+// temperature slopes expressed in unit u. Gains are unitless and unchanged.
+func ConvertCorrections(corrections []*Correction, u Unit) []*Correction {
+	f := u.toMS2()
+
+	converted := make([]*Correction, 0, len(corrections))
+	for _, c := range corrections {
+		cc := *c
+		cc.Offset /= f
+		cc.TempSlope /= f
+		converted = append(converted, &cc)
+	}
+
+	return converted
+}
+
+// Returns copies of the records, which are in m/s², expressed in unit u
+func ConvertRecords(records []*Record, u Unit) []*Record {
+	f := u.toMS2()
+
+	converted := make([]*Record, 0, len(records))
+	for _, r := range records {
+		rr := *r
+		rr.AccX /= f
+		rr.AccY /= f
+		rr.AccZ /= f
+		converted = append(converted, &rr)
+	}
+
+	return converted
+}